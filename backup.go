@@ -0,0 +1,182 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backups live under refs/glt/backup/<id>/, followed by the name of the
+// saved ref without its "refs/" prefix, e.g.
+// refs/glt/backup/20170309-215727/heads/master. The tips the rewrite moved
+// the refs to are kept alongside under refs/glt/rewritten/<id>/.
+const backupPrefix = "refs/glt/backup/"
+
+const rewrittenPrefix = "refs/glt/rewritten/"
+
+const backupIdLayout = "20060102-150405"
+
+// The tips of all refs moved by one rewrite, as they were before it
+type Backup struct {
+	Id   string
+	Refs []*gogit.Reference
+
+	// Where the rewrite moved each ref, by name
+	Rewritten map[string]*gogit.Oid
+}
+
+func (b *Backup) Time() time.Time {
+	t, _ := time.ParseInLocation(backupIdLayout, strings.SplitN(b.Id, ".", 2)[0], time.Local)
+	return t
+}
+
+func backupRefName(prefix, id, ref string) string {
+	return prefix + id + "/" + strings.TrimPrefix(ref, "refs/")
+}
+
+func originalRefName(prefix, backupRef string) (string, string) {
+	rest := strings.TrimPrefix(backupRef, prefix)
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	if parts[1] == "HEAD" {
+		return parts[0], "HEAD"
+	}
+	return parts[0], "refs/" + parts[1]
+}
+
+// Saves the old and new tips of updates under a new backup id and returns it
+func (r *Repo) createBackup(refUpdates []*RefUpdate) (string, error) {
+	backups, err := r.Backups()
+	if err != nil {
+		return "", err
+	}
+	taken := make(map[string]bool)
+	for _, b := range backups {
+		taken[b.Id] = true
+	}
+
+	id := time.Now().Format(backupIdLayout)
+	for i := 2; taken[id]; i++ {
+		id = fmt.Sprintf("%s.%d", time.Now().Format(backupIdLayout), i)
+	}
+
+	var updates []*RefUpdate
+	for _, update := range refUpdates {
+		updates = append(updates,
+			&RefUpdate{Name: backupRefName(backupPrefix, id, update.Name), New: update.Old},
+			&RefUpdate{Name: backupRefName(rewrittenPrefix, id, update.Name), New: update.New})
+	}
+	if err := r.updateRefs(updates, ""); err != nil {
+		return "", fmt.Errorf("Error writing backup %s: %s", id, err)
+	}
+	log.Printf("Backup %s written", id)
+	return id, nil
+}
+
+// Lists all backups, newest first
+func (r *Repo) Backups() ([]*Backup, error) {
+	refs, err := r.listRefs(backupPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*Backup)
+	var backups []*Backup
+	for _, ref := range refs {
+		id, name := originalRefName(backupPrefix, ref.Name)
+		if id == "" {
+			continue
+		}
+		b, ok := byId[id]
+		if !ok {
+			b = &Backup{Id: id, Rewritten: make(map[string]*gogit.Oid)}
+			byId[id] = b
+			backups = append(backups, b)
+		}
+		b.Refs = append(b.Refs, &gogit.Reference{Name: name, Oid: ref.Oid})
+	}
+
+	rewritten, err := r.listRefs(rewrittenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range rewritten {
+		id, name := originalRefName(rewrittenPrefix, ref.Name)
+		if b, ok := byId[id]; ok {
+			b.Rewritten[name] = ref.Oid
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		ti, tj := backups[i].Time(), backups[j].Time()
+		if ti.Equal(tj) {
+			return backups[i].Id > backups[j].Id
+		}
+		return ti.After(tj)
+	})
+	return backups, nil
+}
+
+// Restores the refs saved in backup id, or the latest backup when id is
+// empty, and removes the backup. Fails without changes if a ref moved since
+// the rewrite. In a dry run only the backup is returned.
+func (r *Repo) Undo(id string) (*Backup, error) {
+	backups, err := r.Backups()
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("No backups to restore")
+	}
+
+	backup := backups[0]
+	if id != "" {
+		backup = nil
+		for _, b := range backups {
+			if b.Id == id {
+				backup = b
+			}
+		}
+		if backup == nil {
+			return nil, fmt.Errorf("No backup with id %s", id)
+		}
+	}
+
+	var updates []*RefUpdate
+	for _, ref := range backup.Refs {
+		rewritten := backup.Rewritten[ref.Name]
+		if current := r.readRef(ref.Name); rewritten != nil && (current == nil || !current.Equal(rewritten)) {
+			moved := "was deleted"
+			if current != nil {
+				moved = "moved to " + current.String()[:12]
+			}
+			return nil, fmt.Errorf("%s %s after the rewrite to %s, not restoring backup %s", ref.Name, moved, rewritten.String()[:12], backup.Id)
+		}
+		updates = append(updates, &RefUpdate{Name: ref.Name, Old: rewritten, New: ref.Oid})
+	}
+	if r.DryRun {
+		log.Printf("Dry run, not restoring backup %s", backup.Id)
+		return backup, nil
+	}
+
+	for _, ref := range backup.Refs {
+		log.Printf("Restoring %s to %s", ref.Name, ref.Oid)
+	}
+	if err := r.updateRefs(updates, "glt: undo to backup "+backup.Id); err != nil {
+		return nil, fmt.Errorf("Error restoring backup %s: %s", backup.Id, err)
+	}
+	for _, ref := range backup.Refs {
+		for _, prefix := range []string{backupPrefix, rewrittenPrefix} {
+			if err := r.deleteRef(backupRefName(prefix, backup.Id, ref.Name)); err != nil {
+				return nil, fmt.Errorf("Error removing backup %s: %s", backup.Id, err)
+			}
+		}
+	}
+	return backup, nil
+}
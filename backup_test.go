@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// editAuthor renames the author of rev and saves it, returning the result.
+func editAuthor(t *testing.T, repo *Repo, rev, name string) *RewriteResult {
+	commit := mustResolve(t, repo, rev)
+	commit.Author.Name = name
+	result, err := repo.SaveCommit(commit)
+	if err != nil {
+		t.Fatalf("SaveCommit(%s) failed: %v", rev, err)
+	}
+	return result
+}
+
+func TestBackupAndUndo(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git branch other HEAD~1`)
+	repo.AllowPublished = true
+	tip, base := mustResolve(t, repo, "main").Oid, mustResolve(t, repo, "other").Oid
+
+	first := editAuthor(t, repo, "main~1", "First")
+	second := editAuthor(t, repo, "main", "Second")
+	if first.Backup == "" || second.Backup == "" || first.Backup == second.Backup {
+		t.Fatalf("backup ids %q and %q", first.Backup, second.Backup)
+	}
+
+	backups, err := repo.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Id != second.Backup || backups[1].Id != first.Backup {
+		t.Fatalf("Backups() = %v, want %s then %s", backups, second.Backup, first.Backup)
+	}
+	// Both branches containing the first edit are saved
+	b := backups[1]
+	want := map[string][2]string{
+		"refs/heads/main":  {tip.String(), first.NewTip.String()},
+		"refs/heads/other": {base.String(), runGit(t, dir, "git rev-parse main~1")},
+	}
+	if len(b.Refs) != len(want) {
+		t.Fatalf("backup %s saved %d refs, want %d", b.Id, len(b.Refs), len(want))
+	}
+	for _, ref := range b.Refs {
+		if ref.Oid.String() != want[ref.Name][0] {
+			t.Errorf("backup %s saved %s at %s, want %s", b.Id, ref.Name, ref.Oid, want[ref.Name][0])
+		}
+		if rewritten := b.Rewritten[ref.Name]; rewritten == nil || rewritten.String() != want[ref.Name][1] {
+			t.Errorf("backup %s rewrote %s to %v, want %s", b.Id, ref.Name, rewritten, want[ref.Name][1])
+		}
+	}
+	if refs := backups[0].Refs; len(refs) != 1 || refs[0].Name != "refs/heads/main" {
+		t.Errorf("backup %s saved %v, want only refs/heads/main", backups[0].Id, refs)
+	}
+
+	// The first backup is stale since main moved again
+	if _, err := repo.Undo(first.Backup); err == nil || !strings.Contains(err.Error(), "refs/heads/main moved to") {
+		t.Errorf("Undo(%s) = %v, want a moved error", first.Backup, err)
+	}
+	if _, err := repo.Undo("nope"); err == nil || !strings.Contains(err.Error(), "No backup with id nope") {
+		t.Errorf("Undo(nope) = %v", err)
+	}
+	if got := runGit(t, dir, "git log -1 --format=%an main"); got != "Second" {
+		t.Fatalf("main changed by a failed undo: %s", got)
+	}
+
+	// A dry run only reports the backup
+	repo.DryRun = true
+	if b, err := repo.Undo(""); err != nil || b.Id != second.Backup {
+		t.Fatalf("dry run Undo() = %v, %v", b, err)
+	}
+	if got := runGit(t, dir, "git log -1 --format=%an main"); got != "Second" {
+		t.Fatalf("main changed by a dry run: %s", got)
+	}
+	repo.DryRun = false
+
+	// Undoing the latest then the first backup gets back to the start
+	for _, id := range []string{"", first.Backup} {
+		if _, err := repo.Undo(id); err != nil {
+			t.Fatalf("Undo(%q) failed: %v", id, err)
+		}
+	}
+	if got := runGit(t, dir, "git rev-parse main other"); got != tip.String()+"\n"+base.String() {
+		t.Errorf("refs after undo:\n%s", got)
+	}
+	if got := runGit(t, dir, "git for-each-ref refs/glt"); got != "" {
+		t.Errorf("backup refs left:\n%s", got)
+	}
+	if _, err := repo.Undo(""); err == nil || err.Error() != "No backups to restore" {
+		t.Errorf("Undo() = %v, want no backups", err)
+	}
+}
+
+func TestUndoDeletedRef(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git checkout -q -b topic
+		git commit -q --allow-empty -m two`)
+	repo.AllowPublished = true
+
+	result := editAuthor(t, repo, "topic", "Edited")
+	runGit(t, dir, "git checkout -q main && git branch -D topic")
+	if _, err := repo.Undo(result.Backup); err == nil || !strings.Contains(err.Error(), "refs/heads/topic was deleted") {
+		t.Errorf("Undo() = %v, want a deleted error", err)
+	}
+	if backups, err := repo.Backups(); err != nil || len(backups) != 1 {
+		t.Errorf("backup removed by a failed undo: %v %v", backups, err)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+var setCommand = cli.Command{
+	Name:      "set",
+	Usage:     "Edit the metadata of a commit without the interface",
+	ArgsUsage: "<rev>",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "author-name", Usage: "New author name"},
+		cli.StringFlag{Name: "author-email", Usage: "New author email"},
+		cli.StringFlag{Name: "author-date", Usage: "New author date, e.g. \"2017-03-09 21:57:27 +0800\", \"yesterday 18:00\" or \"+3h\""},
+		cli.StringFlag{Name: "committer-name", Usage: "New committer name"},
+		cli.StringFlag{Name: "committer-email", Usage: "New committer email"},
+		cli.StringFlag{Name: "committer-date", Usage: "New committer date, in the same formats as --author-date"},
+		cli.StringFlag{Name: "message-file", Usage: "Read the new message from `FILE` ('-' for stdin)"},
+		dryRunFlag,
+		allowPublishedFlag,
+	},
+	Action: setCommit,
+}
+
+func readMessageFile(name string) (string, error) {
+	var content []byte
+	var err error
+	if name == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	message := strings.TrimRight(string(content), "\n")
+	if message == "" {
+		return "", fmt.Errorf("Message is empty")
+	}
+	return message + "\n", nil
+}
+
+var dryRunFlag = cli.BoolFlag{
+	Name:  "n, dry-run",
+	Usage: "Show the rewrite plan without changing any ref",
+}
+
+func isDryRun(c *cli.Context) bool {
+	return c.Bool("dry-run") || c.GlobalBool("dry-run")
+}
+
+var allowPublishedFlag = cli.BoolFlag{
+	Name:  "allow-published",
+	Usage: "Rewrite commits that are already on a remote without asking",
+}
+
+// Opens the repository given by --repo, or the one containing the current
+// directory, and selects the branch given by --branch
+func openRepository(c *cli.Context) (*Repo, error) {
+	path := c.GlobalString("repo")
+	if path == "" {
+		path = c.String("repo")
+	}
+	repo, err := OpenRepository(path)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := c.GlobalString("branch")
+	if branch == "" {
+		branch = c.String("branch")
+	}
+	if branch != "" {
+		if err := repo.UseRef(branch); err != nil {
+			return nil, err
+		}
+	}
+	repo.AllowPublished = c.Bool("allow-published") || c.GlobalBool("allow-published")
+	return repo, nil
+}
+
+func printResult(result *RewriteResult) {
+	if result.DryRun {
+		fmt.Println("Dry run, no refs were changed.")
+		fmt.Println()
+		for _, line := range formatPlan(result) {
+			fmt.Println(line)
+		}
+		return
+	}
+	fmt.Printf("Changed: %s\n", result.RefNames())
+	if len(result.UnsignedTags) > 0 {
+		fmt.Println(formatUnsignedTags(result, "dropped"))
+	}
+	fmt.Printf("Backup: %s (restore with 'glt undo %s')\n", result.Backup, result.Backup)
+}
+
+func setCommit(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("usage: glt set <rev> [options]", 2)
+	}
+
+	if !c.GlobalIsSet("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	repo, err := openRepository(c)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %v", err)
+	}
+
+	repo.DryRun = isDryRun(c)
+
+	commit, err := repo.ResolveCommit(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if c.IsSet("author-name") {
+		commit.Author.Name = c.String("author-name")
+	}
+	if c.IsSet("author-email") {
+		commit.Author.Email = c.String("author-email")
+	}
+	if c.IsSet("author-date") {
+		if commit.Author.When, err = parseDate(c.String("author-date"), commit.Author.When); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("committer-name") {
+		commit.Committer.Name = c.String("committer-name")
+	}
+	if c.IsSet("committer-email") {
+		commit.Committer.Email = c.String("committer-email")
+	}
+	if c.IsSet("committer-date") {
+		if commit.Committer.When, err = parseDate(c.String("committer-date"), commit.Committer.When); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("message-file") {
+		if commit.CommitMessage, err = readMessageFile(c.String("message-file")); err != nil {
+			return fmt.Errorf("error reading message: %v", err)
+		}
+	}
+
+	result, err := repo.SaveCommitIfModified(commit)
+	if err != nil {
+		return fmt.Errorf("Error saving commit: %s", err)
+	}
+	if result == nil {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	fmt.Printf("%s -> %s\n", commit.Oid, result.NewId(commit.Oid))
+	printResult(result)
+	return nil
+}
+
+var replaceIdentityCommand = cli.Command{
+	Name:      "replace-identity",
+	Usage:     "Replace an author/committer identity on every matching commit",
+	ArgsUsage: "[range]",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "from", Usage: "Identity to replace, \"Name <email>\", \"<email>\" or \"Name\""},
+		cli.StringFlag{Name: "to", Usage: "New identity, \"Name <email>\""},
+		cli.BoolFlag{Name: "author-only", Usage: "Leave committers untouched"},
+		cli.BoolFlag{Name: "committer-only", Usage: "Leave authors untouched"},
+		dryRunFlag,
+		allowPublishedFlag,
+	},
+	Action: replaceIdentity,
+}
+
+func replaceIdentity(c *cli.Context) error {
+	if c.NArg() > 1 || !c.IsSet("from") || !c.IsSet("to") {
+		return cli.NewExitError("usage: glt replace-identity --from <identity> --to <identity> [range]", 2)
+	}
+	if c.Bool("author-only") && c.Bool("committer-only") {
+		return cli.NewExitError("--author-only and --committer-only are mutually exclusive", 2)
+	}
+
+	if !c.GlobalIsSet("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	from, err := parseIdentity(c.String("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseIdentity(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	repo, err := openRepository(c)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %v", err)
+	}
+
+	repo.DryRun = isDryRun(c)
+
+	spec := "HEAD"
+	if repo.Ref != "" {
+		spec = repo.Ref
+	}
+	if c.NArg() == 1 {
+		spec = c.Args().First()
+	}
+	commits, err := repo.RangeCommits(spec)
+	if err != nil {
+		return err
+	}
+
+	result, count, err := repo.ReplaceIdentity(commits, from, to, !c.Bool("committer-only"), !c.Bool("author-only"))
+	if err != nil {
+		return fmt.Errorf("Error saving commits: %s", err)
+	}
+	if result == nil {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	fmt.Printf("Rewrote %d commits: %s -> %s\n", count, result.OldTip, result.NewTip)
+	printResult(result)
+	return nil
+}
+
+var backupsCommand = cli.Command{
+	Name:   "backups",
+	Usage:  "List the refs saved before each rewrite",
+	Action: listBackups,
+}
+
+func listBackups(c *cli.Context) error {
+	repo, err := openRepository(c)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %v", err)
+	}
+
+	backups, err := repo.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups.")
+		return nil
+	}
+	for _, backup := range backups {
+		for _, ref := range backup.Refs {
+			fmt.Printf("%s  %s  %s  %s\n", backup.Id, ref.Oid.String()[:12], ref.Name, repo.subject(ref.Oid))
+		}
+	}
+	return nil
+}
+
+var undoCommand = cli.Command{
+	Name:      "undo",
+	Usage:     "Restore the refs saved before a rewrite (default: the latest)",
+	ArgsUsage: "[id]",
+	Flags:     []cli.Flag{dryRunFlag},
+	Action:    undo,
+}
+
+func undo(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("usage: glt undo [id]", 2)
+	}
+
+	if !c.GlobalIsSet("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	repo, err := openRepository(c)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %v", err)
+	}
+	repo.DryRun = isDryRun(c)
+
+	backup, err := repo.Undo(c.Args().First())
+	if err != nil {
+		return err
+	}
+	verb := "Restored"
+	if repo.DryRun {
+		fmt.Println("Dry run, no refs were changed.")
+		verb = "Would restore"
+	}
+	for _, ref := range backup.Refs {
+		fmt.Printf("%s: %s to %s\n", verb, ref.Name, ref.Oid)
+	}
+	return nil
+}
+
+var pushCommand = cli.Command{
+	Name:      "push",
+	Usage:     "Push a rewritten branch to its upstream with --force-with-lease",
+	ArgsUsage: "[branch]",
+	Flags:     []cli.Flag{dryRunFlag},
+	Action:    pushBranch,
+}
+
+func pushBranch(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("usage: glt push [branch]", 2)
+	}
+
+	if !c.GlobalIsSet("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	repo, err := openRepository(c)
+	if err != nil {
+		return fmt.Errorf("error opening repository: %v", err)
+	}
+	if c.NArg() == 1 {
+		if err := repo.UseRef(c.Args().First()); err != nil {
+			return err
+		}
+	}
+	name, _, err := repo.resolveTarget()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(name, "refs/heads/") {
+		return fmt.Errorf("%s is not a branch", name)
+	}
+
+	upstream, err := repo.UpstreamOf(name)
+	if err != nil {
+		return err
+	}
+	lease, err := repo.RemoteTip(upstream)
+	if err != nil {
+		return err
+	}
+	if isDryRun(c) {
+		fmt.Printf("Dry run, would run: git %s\n", strings.Join(pushArgs(name, upstream, lease), " "))
+		return nil
+	}
+
+	output, err := repo.Push(name, upstream, lease)
+	fmt.Print(output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %s to %s, replacing %s\n", shortRefName(name), upstream, lease.String()[:12])
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of dates as printed by time.Time.String()
+const dateLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// Layout of the date field in the edit form. The offset is edited in a
+// field of its own.
+const localDateLayout = "2006-01-02 15:04:05"
+
+const offsetLayout = "-0700"
+
+// Layouts that carry their own timezone offset: ISO 8601, RFC 2822 and the
+// format of git log
+var zonedDateLayouts = []string{
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	dateLayout,
+}
+
+// Layouts without an offset, read in the zone of the date they replace
+var localDateLayouts = []string{
+	localDateLayout,
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"Mon, 2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04:05",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+var (
+	// git's raw "<unix seconds> <offset>"
+	rawDatePattern = regexp.MustCompile(`^(\d+) ([+-]\d{4})$`)
+
+	// "+3h", "-2d", "+1d12h"
+	shiftPattern     = regexp.MustCompile(`^([+-])((?:\d+[smhdw])+)$`)
+	shiftPartPattern = regexp.MustCompile(`(\d+)([smhdw])`)
+
+	// "now", "yesterday", "today 18:00", "tomorrow 09:30:15"
+	dayPattern = regexp.MustCompile(`^(now|today|yesterday|tomorrow)(?: (\d{1,2}):(\d{2})(?::(\d{2}))?)?$`)
+)
+
+var shiftUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// Parses a date given in one of many formats. Dates without an offset are
+// read in the zone of base, shifts like "+3h" are relative to base, and
+// "yesterday 18:00" and friends are relative to the current day.
+func parseDate(value string, base time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	loc := base.Location()
+
+	if m := rawDatePattern.FindStringSubmatch(value); m != nil {
+		seconds, err := strconv.ParseInt(m[1], 10, 64)
+		if zone, zoneErr := parseOffset(m[2]); err == nil && zoneErr == nil {
+			return time.Unix(seconds, 0).In(zone), nil
+		}
+	}
+
+	if m := shiftPattern.FindStringSubmatch(value); m != nil {
+		var shift time.Duration
+		for _, part := range shiftPartPattern.FindAllStringSubmatch(m[2], -1) {
+			n, _ := strconv.Atoi(part[1])
+			shift += time.Duration(n) * shiftUnits[part[2]]
+		}
+		if m[1] == "-" {
+			shift = -shift
+		}
+		return base.Add(shift), nil
+	}
+
+	if m := dayPattern.FindStringSubmatch(strings.ToLower(value)); m != nil {
+		now := time.Now().In(loc).Truncate(time.Second)
+		switch m[1] {
+		case "yesterday":
+			now = now.AddDate(0, 0, -1)
+		case "tomorrow":
+			now = now.AddDate(0, 0, 1)
+		}
+		if m[2] == "" {
+			return now, nil
+		}
+		if m[1] == "now" {
+			return time.Time{}, fmt.Errorf("Unrecognised date %q", value)
+		}
+		hour, _ := strconv.Atoi(m[2])
+		minute, _ := strconv.Atoi(m[3])
+		second, _ := strconv.Atoi(m[4])
+		if hour > 23 || minute > 59 || second > 59 {
+			return time.Time{}, fmt.Errorf("Invalid time of day in %q", value)
+		}
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, second, 0, loc), nil
+	}
+
+	for _, layout := range zonedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			// Keep the offset of the value, not whatever zone Go matched it with
+			_, offset := t.Zone()
+			return t.In(time.FixedZone("", offset)), nil
+		}
+	}
+	for _, layout := range localDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unrecognised date %q", value)
+}
+
+// Parses a timezone offset like "+0200", "-05:30" or "Z"
+func parseOffset(value string) (*time.Location, error) {
+	value = strings.TrimSpace(value)
+	if value == "Z" || value == "z" {
+		return time.FixedZone("", 0), nil
+	}
+	for _, layout := range []string{offsetLayout, "-07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			_, offset := t.Zone()
+			return time.FixedZone("", offset), nil
+		}
+	}
+	return nil, fmt.Errorf("Unrecognised timezone offset %q", value)
+}
+
+// Returns t with the same wall clock time in the zone loc
+func withOffset(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// Reports whether two signature times are the same instant with the same
+// timezone offset.
+func sameTime(t1, t2 time.Time) bool {
+	return t1.Equal(t2) && t1.Format(offsetLayout) == t2.Format(offsetLayout)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testDateLayout = "2006-01-02 15:04:05 -0700"
+
+func TestParseDate(t *testing.T) {
+	// 2017-03-09 21:57:27 +0800
+	base := time.Unix(1489067847, 0).In(time.FixedZone("", 8*3600))
+
+	for _, c := range []struct{ value, exp string }{
+		// ISO 8601
+		{"2017-03-09 21:57:27 +0800", "2017-03-09 21:57:27 +0800"},
+		{"2017-03-09 21:57 -0500", "2017-03-09 21:57:00 -0500"},
+		{"2017-03-09T21:57:27Z", "2017-03-09 21:57:27 +0000"},
+		{"2017-03-09T21:57:27+05:30", "2017-03-09 21:57:27 +0530"},
+		{"2017-03-09T21:57:27-0130", "2017-03-09 21:57:27 -0130"},
+		{"2017-03-09T21:57+01:00", "2017-03-09 21:57:00 +0100"},
+		// RFC 2822
+		{"Thu, 9 Mar 2017 21:57:27 +0800", "2017-03-09 21:57:27 +0800"},
+		{"9 Mar 2017 21:57:27 -0700", "2017-03-09 21:57:27 -0700"},
+		// git log and time.Time.String()
+		{"Thu Mar 9 21:57:27 2017 +0100", "2017-03-09 21:57:27 +0100"},
+		{"2017-03-09 21:57:27 +0100 CET", "2017-03-09 21:57:27 +0100"},
+		// git's raw format
+		{"1489067847 +0800", "2017-03-09 21:57:27 +0800"},
+		{"1489067847 -0000", "2017-03-09 13:57:27 +0000"},
+		// Without an offset, in the zone of base
+		{"2017-01-02 03:04:05", "2017-01-02 03:04:05 +0800"},
+		{"2017-01-02 03:04", "2017-01-02 03:04:00 +0800"},
+		{"2017-01-02T03:04:05", "2017-01-02 03:04:05 +0800"},
+		{"2017-01-02", "2017-01-02 00:00:00 +0800"},
+		{"Mon, 2 Jan 2017 03:04:05", "2017-01-02 03:04:05 +0800"},
+		{"Mon Jan 2 03:04:05 2017", "2017-01-02 03:04:05 +0800"},
+		// Shifts from base
+		{"+3h", "2017-03-10 00:57:27 +0800"},
+		{"-1d12h", "2017-03-08 09:57:27 +0800"},
+		{"+1w", "2017-03-16 21:57:27 +0800"},
+		{"-90m", "2017-03-09 20:27:27 +0800"},
+		{"  +30s  ", "2017-03-09 21:57:57 +0800"},
+	} {
+		got, err := parseDate(c.value, base)
+		if err != nil {
+			t.Errorf("parseDate(%q) failed: %v", c.value, err)
+			continue
+		}
+		if got.Format(testDateLayout) != c.exp {
+			t.Errorf("parseDate(%q) = %s, want %s", c.value, got.Format(testDateLayout), c.exp)
+		}
+	}
+}
+
+func TestParseDateRelativeDays(t *testing.T) {
+	zone := time.FixedZone("", -7*3600)
+	base := time.Unix(1489067847, 0).In(zone)
+
+	for _, c := range []struct {
+		value        string
+		days         int
+		hour, minute int
+	}{
+		{"yesterday 18:00", -1, 18, 0},
+		{"today 09:30", 0, 9, 30},
+		{"Tomorrow 23:59", 1, 23, 59},
+	} {
+		before := time.Now().In(zone)
+		got, err := parseDate(c.value, base)
+		if err != nil {
+			t.Errorf("parseDate(%q) failed: %v", c.value, err)
+			continue
+		}
+		after := time.Now().In(zone)
+		day := got.AddDate(0, 0, -c.days).Format("2006-01-02")
+		if day != before.Format("2006-01-02") && day != after.Format("2006-01-02") {
+			t.Errorf("parseDate(%q) = %s, wrong day", c.value, got)
+		}
+		if got.Hour() != c.hour || got.Minute() != c.minute || got.Second() != 0 || got.Format("-0700") != "-0700" {
+			t.Errorf("parseDate(%q) = %s", c.value, got.Format(testDateLayout))
+		}
+	}
+
+	for _, value := range []string{"now", "today", "yesterday"} {
+		got, err := parseDate(value, base)
+		if err != nil {
+			t.Errorf("parseDate(%q) failed: %v", value, err)
+		}
+		if got.Format("-0700") != "-0700" {
+			t.Errorf("parseDate(%q) = %s, not in the zone of base", value, got)
+		}
+	}
+}
+
+func TestParseDateInvalid(t *testing.T) {
+	base := time.Unix(1489067847, 0).In(time.FixedZone("", 8*3600))
+	for _, value := range []string{
+		"",
+		"garbage",
+		"2017-13-01",
+		"2017-02-30 10:00:00",
+		"2017-03-09 25:00:00 +0800",
+		"2017-03-09 21:57:27 +08",
+		"1489067847",
+		"1489067847 0800",
+		"today 25:00",
+		"yesterday 18:60",
+		"now 10:00",
+		"+3",
+		"+3y",
+		"3h",
+		"0001-01-01 00:00:00 +0000 UTC garbage",
+	} {
+		if got, err := parseDate(value, base); err == nil {
+			t.Errorf("parseDate(%q) = %s, want an error", value, got)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	for _, c := range []struct {
+		value  string
+		offset int
+	}{
+		{"+0800", 8 * 3600},
+		{"-0130", -(1*3600 + 30*60)},
+		{"+05:30", 5*3600 + 30*60},
+		{"Z", 0},
+		{" -0000 ", 0},
+	} {
+		loc, err := parseOffset(c.value)
+		if err != nil {
+			t.Errorf("parseOffset(%q) failed: %v", c.value, err)
+			continue
+		}
+		if _, offset := time.Unix(0, 0).In(loc).Zone(); offset != c.offset {
+			t.Errorf("parseOffset(%q) = %d, want %d", c.value, offset, c.offset)
+		}
+	}
+	for _, value := range []string{"", "CET", "+8", "0800", "+08:0"} {
+		if _, err := parseOffset(value); err == nil {
+			t.Errorf("parseOffset(%q) accepted", value)
+		}
+	}
+}
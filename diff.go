@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A file changed by a commit, with its status as shown by git diff
+// --name-status: "A" added, "M" modified or "D" deleted.
+type FileChange struct {
+	Status string
+	Path   string
+}
+
+// Lists every entry of the tree oid by path. Subtrees whose path maps to
+// the same id in skip are not descended into.
+func (r *Repo) treeEntries(oid *gogit.Oid, skip map[string]*gogit.TreeEntry) (entries map[string]*gogit.TreeEntry, err error) {
+	tree, err := r.repository.LookupTree(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk panics if a subtree cannot be read
+	defer func() {
+		if e := recover(); e != nil {
+			entries, err = nil, fmt.Errorf("Error reading tree %s: %v", oid, e)
+		}
+	}()
+
+	entries = make(map[string]*gogit.TreeEntry)
+	tree.Walk(func(dir string, entry *gogit.TreeEntry) int {
+		name := path.Join(dir, entry.Name)
+		entries[name] = entry
+		if other, ok := skip[name]; ok && entry.Type == gogit.ObjectTree && other.Id.Equal(entry.Id) {
+			return 1
+		}
+		return 0
+	})
+	return entries, nil
+}
+
+// Lists the files commit changed compared to its first parent, sorted by
+// path. All files of a root commit are added.
+func (r *Repo) ChangedFiles(commit *gogit.Commit) ([]FileChange, error) {
+	before := make(map[string]*gogit.TreeEntry)
+	if commit.ParentCount() > 0 {
+		parent, err := r.repository.LookupCommit(commit.ParentId(0))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading parent of %s: %s", commit.Oid, err)
+		}
+		if before, err = r.treeEntries(parent.TreeId(), nil); err != nil {
+			return nil, err
+		}
+	}
+	after, err := r.treeEntries(commit.TreeId(), before)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries below a subtree that did not change were not walked
+	var unchanged []string
+	for name, entry := range after {
+		if entry.Type == gogit.ObjectTree && before[name] != nil && before[name].Id.Equal(entry.Id) {
+			unchanged = append(unchanged, name+"/")
+		}
+	}
+	walked := func(name string) bool {
+		for _, prefix := range unchanged {
+			if strings.HasPrefix(name, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var changes []FileChange
+	for name, entry := range after {
+		if entry.Type == gogit.ObjectTree {
+			continue
+		}
+		old, ok := before[name]
+		switch {
+		case !ok || old.Type == gogit.ObjectTree:
+			changes = append(changes, FileChange{"A", name})
+		case !old.Id.Equal(entry.Id) || old.Filemode != entry.Filemode:
+			changes = append(changes, FileChange{"M", name})
+		}
+	}
+	for name, entry := range before {
+		if entry.Type == gogit.ObjectTree || !walked(name) {
+			continue
+		}
+		if now, ok := after[name]; !ok || now.Type == gogit.ObjectTree {
+			changes = append(changes, FileChange{"D", name})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Describes commit for the detail view: ids, parents, signatures, the full
+// message and the files it changed.
+func (r *Repo) commitDetail(commit *gogit.Commit) []string {
+	lines := []string{
+		"Commit   : " + commit.Oid.String(),
+		"Tree     : " + commit.TreeId().String(),
+	}
+	for i := 0; i < commit.ParentCount(); i++ {
+		parent := commit.ParentId(i)
+		lines = append(lines, fmt.Sprintf("Parent %d : %s %s", i+1, parent, r.subject(parent)))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Author   : %s <%s> %s", commit.Author.Name, commit.Author.Email, commit.Author.When.Format(planDateLayout)),
+		fmt.Sprintf("Committer: %s <%s> %s", commit.Committer.Name, commit.Committer.Email, commit.Committer.When.Format(planDateLayout)),
+		"",
+	)
+	for _, line := range strings.Split(strings.TrimRight(commit.CommitMessage, "\n"), "\n") {
+		lines = append(lines, "    "+line)
+	}
+	lines = append(lines, "")
+
+	changes, err := r.ChangedFiles(commit)
+	if err != nil {
+		return append(lines, err.Error())
+	}
+	switch {
+	case commit.ParentCount() == 0:
+		lines = append(lines, fmt.Sprintf("%d files in the root commit:", len(changes)))
+	case len(changes) == 1:
+		lines = append(lines, "1 file changed since the first parent:")
+	default:
+		lines = append(lines, fmt.Sprintf("%d files changed since the first parent:", len(changes)))
+	}
+	for _, change := range changes {
+		lines = append(lines, fmt.Sprintf("  %s  %s", change.Status, change.Path))
+	}
+	return lines
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where the parts of a repository live. In a linked worktree gitDir holds
+// HEAD and the index while commonDir holds objects and refs; otherwise both
+// are the same. workTree is empty for bare repositories.
+type repoPaths struct {
+	gitDir    string
+	commonDir string
+	workTree  string
+}
+
+// Finds the repository like git does: GIT_DIR and GIT_WORK_TREE if set,
+// otherwise the first of start and its parents that contains a .git
+// directory or file, or is a bare repository itself.
+func discoverRepository(start string) (*repoPaths, error) {
+	start, err := filepath.Abs(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if gitDir := os.Getenv("GIT_DIR"); gitDir != "" {
+		paths := &repoPaths{workTree: os.Getenv("GIT_WORK_TREE")}
+		if paths.gitDir, err = resolveGitDir(gitDir, start); err != nil {
+			return nil, err
+		}
+		if paths.workTree == "" && !isBare(paths.gitDir) {
+			paths.workTree = start
+		}
+		return paths.withCommonDir()
+	}
+
+	for dir := start; ; dir = filepath.Dir(dir) {
+		dotGit := filepath.Join(dir, ".git")
+		if _, err := os.Stat(dotGit); err == nil {
+			gitDir, err := resolveGitDir(dotGit, dir)
+			if err != nil {
+				return nil, err
+			}
+			paths := &repoPaths{gitDir: gitDir, workTree: dir}
+			if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "" {
+				paths.workTree = workTree
+			}
+			return paths.withCommonDir()
+		}
+		if isGitDir(dir) {
+			paths := &repoPaths{gitDir: dir}
+			if !isBare(dir) && filepath.Base(dir) == ".git" {
+				paths.workTree = filepath.Dir(dir)
+			}
+			return paths.withCommonDir()
+		}
+		if filepath.Dir(dir) == dir {
+			return nil, fmt.Errorf("Not a git repository (or any of the parent directories): %s", start)
+		}
+	}
+}
+
+// Returns the git directory at path, following "gitdir: <path>" if path is
+// a .git file. Relative paths are taken from base.
+func resolveGitDir(path, base string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		content = bytes.TrimSpace(content)
+		if !bytes.HasPrefix(content, []byte("gitdir: ")) {
+			return "", fmt.Errorf("Invalid gitfile format: %s", path)
+		}
+		return resolveGitDir(string(content[len("gitdir: "):]), filepath.Dir(path))
+	}
+	if !isGitDir(path) {
+		return "", fmt.Errorf("Not a git repository: %s", path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// Reports whether dir looks like a git directory: a HEAD file, and objects
+// and refs either in dir or in its commondir.
+func isGitDir(dir string) bool {
+	if info, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil || info.IsDir() {
+		return false
+	}
+	common := dir
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		common = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(dir, common)
+		}
+	}
+	for _, sub := range []string{"objects", "refs"} {
+		if info, err := os.Stat(filepath.Join(common, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the repository at gitDir is configured as bare
+func isBare(gitDir string) bool {
+	content, err := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(strings.Replace(line, "=", " = ", 1))
+		if len(fields) == 3 && strings.EqualFold(fields[0], "bare") && fields[1] == "=" {
+			return strings.EqualFold(fields[2], "true")
+		}
+	}
+	return false
+}
+
+func (paths *repoPaths) withCommonDir() (*repoPaths, error) {
+	paths.commonDir = paths.gitDir
+	content, err := ioutil.ReadFile(filepath.Join(paths.gitDir, "commondir"))
+	if os.IsNotExist(err) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	common := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(paths.gitDir, common)
+	}
+	paths.commonDir = filepath.Clean(common)
+	return paths, nil
+}
+
+// Opens the repository containing path, or the current directory if path
+// is empty.
+func OpenRepository(path string) (*Repo, error) {
+	if path == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		path = wd
+	}
+
+	paths, err := discoverRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := gogit.OpenRepository(paths.commonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{
+		repository: repository,
+		gitDir:     paths.gitDir,
+		workTree:   paths.workTree,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setEnv sets the environment variable key to value, or unsets it if value
+// is empty, and returns a function restoring the old value.
+func setEnv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestDiscoverRepository(t *testing.T) {
+	_, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		mkdir -p sub/deep
+		git worktree add -q ../wt -b feature HEAD~1
+		git clone -q --bare . ../bare.git`)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := filepath.Dir(dir)
+	gitDir := filepath.Join(dir, ".git")
+	wtGitDir := filepath.Join(gitDir, "worktrees", "wt")
+	bare := filepath.Join(parent, "bare.git")
+
+	tests := []struct {
+		name, start, gitDirEnv, workTreeEnv string
+		want                                repoPaths
+		head                                string
+	}{
+		{"top", dir, "", "", repoPaths{gitDir, gitDir, dir}, "refs/heads/main"},
+		{"subdirectory", filepath.Join(dir, "sub", "deep"), "", "", repoPaths{gitDir, gitDir, dir}, "refs/heads/main"},
+		{"inside .git", filepath.Join(gitDir, "refs"), "", "", repoPaths{gitDir, gitDir, dir}, "refs/heads/main"},
+		{"worktree", filepath.Join(parent, "wt"), "", "", repoPaths{wtGitDir, gitDir, filepath.Join(parent, "wt")}, "refs/heads/feature"},
+		{"bare", bare, "", "", repoPaths{bare, bare, ""}, "refs/heads/main"},
+		{"inside bare", filepath.Join(bare, "refs", "heads"), "", "", repoPaths{bare, bare, ""}, "refs/heads/main"},
+		{"GIT_DIR", parent, gitDir, "", repoPaths{gitDir, gitDir, parent}, "refs/heads/main"},
+		{"relative GIT_DIR", dir, ".git", "", repoPaths{gitDir, gitDir, dir}, "refs/heads/main"},
+		{"GIT_DIR worktree file", parent, filepath.Join(parent, "wt", ".git"), filepath.Join(parent, "wt"), repoPaths{wtGitDir, gitDir, filepath.Join(parent, "wt")}, "refs/heads/feature"},
+		{"GIT_DIR bare", parent, bare, "", repoPaths{bare, bare, ""}, "refs/heads/main"},
+		{"GIT_WORK_TREE", filepath.Join(dir, "sub"), "", parent, repoPaths{gitDir, gitDir, parent}, "refs/heads/main"},
+	}
+	for _, test := range tests {
+		restoreDir := setEnv("GIT_DIR", test.gitDirEnv)
+		restoreWorkTree := setEnv("GIT_WORK_TREE", test.workTreeEnv)
+		paths, err := discoverRepository(test.start)
+		var repo *Repo
+		if err == nil {
+			repo, err = OpenRepository(test.start)
+		}
+		restoreDir()
+		restoreWorkTree()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if *paths != test.want {
+			t.Errorf("%s: discoverRepository(%s) = %+v, want %+v", test.name, test.start, *paths, test.want)
+		}
+		if name, _, err := repo.resolveHead(); err != nil || name != test.head {
+			t.Errorf("%s: HEAD is %s %v, want %s", test.name, name, err, test.head)
+		}
+	}
+
+	if _, err := discoverRepository(parent); err == nil || !strings.HasPrefix(err.Error(), "Not a git repository") {
+		t.Errorf("discoverRepository(%s) = %v, want no repository", parent, err)
+	}
+	restore := setEnv("GIT_DIR", filepath.Join(dir, "sub"))
+	_, err = discoverRepository(dir)
+	restore()
+	if err == nil {
+		t.Errorf("GIT_DIR without a repository accepted")
+	}
+}
+
+// Rewriting in a linked worktree moves its own branch, and its HEAD
+func TestWorktreeRewrite(t *testing.T) {
+	_, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git worktree add -q ../wt -b feature HEAD~1
+		git -C ../wt commit -q --allow-empty -m three`)
+	wt := filepath.Join(filepath.Dir(dir), "wt")
+	repo, err := OpenRepository(wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.AllowPublished = true
+	main := runGit(t, dir, "git rev-parse main")
+
+	commit := mustResolve(t, repo, "HEAD")
+	commit.Author.Name = "Edited"
+	if _, err := repo.SaveCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, wt, "git log -1 --format=%an; git rev-parse main; git status --porcelain"); got != "Edited\n"+main {
+		t.Errorf("worktree after rewrite:\n%s", got)
+	}
+}
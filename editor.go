@@ -0,0 +1,297 @@
+package main
+
+import (
+	gc "github.com/rthornton128/goncurses"
+
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	subjectLimit = 50
+	bodyLimit    = 72
+)
+
+// A messageEditor holds the text and cursor of the commit message editor.
+// Body lines are hard wrapped at bodyLimit while typing, the subject line is
+// only marked when it runs past subjectLimit.
+type messageEditor struct {
+	lines    [][]rune
+	row, col int
+	top      int
+	modified bool
+	pending  []byte // start of a multi-byte character
+}
+
+func newMessageEditor(message string) *messageEditor {
+	message = strings.TrimRight(message, "\n")
+	e := &messageEditor{}
+	for _, line := range strings.Split(message, "\n") {
+		e.lines = append(e.lines, []rune(line))
+	}
+	return e
+}
+
+// Returns the edited message, with trailing blank lines collapsed into a
+// single newline as git does.
+func (e *messageEditor) message() string {
+	lines := make([]string, len(e.lines))
+	for i, line := range e.lines {
+		lines[i] = strings.TrimRight(string(line), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+func lineLimit(row int) int {
+	if row == 0 {
+		return subjectLimit
+	}
+	return bodyLimit
+}
+
+func (e *messageEditor) insert(r rune) {
+	line := e.lines[e.row]
+	line = append(line[:e.col], append([]rune{r}, line[e.col:]...)...)
+	e.lines[e.row] = line
+	e.col++
+	e.modified = true
+	e.wrap()
+}
+
+// Inserts the next byte of UTF-8 input. Multi-byte characters arrive one
+// byte at a time and are inserted once complete, invalid bytes are dropped.
+func (e *messageEditor) inputByte(b byte) {
+	e.pending = append(e.pending, b)
+	for len(e.pending) > 0 && utf8.FullRune(e.pending) {
+		r, size := utf8.DecodeRune(e.pending)
+		e.pending = e.pending[size:]
+		if r != utf8.RuneError || size > 1 {
+			e.insert(r)
+		}
+	}
+}
+
+// Breaks the current body line at the last space before bodyLimit
+func (e *messageEditor) wrap() {
+	line := e.lines[e.row]
+	if e.row == 0 || len(line) <= bodyLimit {
+		return
+	}
+	split := -1
+	for i := bodyLimit; i > 0; i-- {
+		if line[i] == ' ' {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return
+	}
+
+	rest := append([]rune(nil), line[split+1:]...)
+	e.lines[e.row] = line[:split]
+	e.lines = append(e.lines[:e.row+1], append([][]rune{rest}, e.lines[e.row+1:]...)...)
+	if e.col > split {
+		e.row++
+		e.col -= split + 1
+	}
+}
+
+func (e *messageEditor) newline() {
+	line := e.lines[e.row]
+	rest := append([]rune(nil), line[e.col:]...)
+	e.lines[e.row] = line[:e.col]
+	e.lines = append(e.lines[:e.row+1], append([][]rune{rest}, e.lines[e.row+1:]...)...)
+	e.row++
+	e.col = 0
+	e.modified = true
+}
+
+func (e *messageEditor) backspace() {
+	switch {
+	case e.col > 0:
+		line := e.lines[e.row]
+		e.lines[e.row] = append(line[:e.col-1], line[e.col:]...)
+		e.col--
+	case e.row > 0:
+		prev := e.lines[e.row-1]
+		e.col = len(prev)
+		e.lines[e.row-1] = append(prev, e.lines[e.row]...)
+		e.lines = append(e.lines[:e.row], e.lines[e.row+1:]...)
+		e.row--
+	default:
+		return
+	}
+	e.modified = true
+}
+
+func (e *messageEditor) deleteChar() {
+	line := e.lines[e.row]
+	switch {
+	case e.col < len(line):
+		e.lines[e.row] = append(line[:e.col], line[e.col+1:]...)
+	case e.row < len(e.lines)-1:
+		e.lines[e.row] = append(line, e.lines[e.row+1]...)
+		e.lines = append(e.lines[:e.row+1], e.lines[e.row+2:]...)
+	default:
+		return
+	}
+	e.modified = true
+}
+
+// Moves the cursor by dy lines, keeping it inside the text
+func (e *messageEditor) moveRow(dy int) {
+	e.row += dy
+	if e.row < 0 {
+		e.row = 0
+	}
+	if e.row >= len(e.lines) {
+		e.row = len(e.lines) - 1
+	}
+	if e.col > len(e.lines[e.row]) {
+		e.col = len(e.lines[e.row])
+	}
+}
+
+func (e *messageEditor) moveCol(dx int) {
+	e.col += dx
+	switch {
+	case e.col < 0 && e.row > 0:
+		e.row--
+		e.col = len(e.lines[e.row])
+	case e.col < 0:
+		e.col = 0
+	case e.col > len(e.lines[e.row]) && e.row < len(e.lines)-1:
+		e.row++
+		e.col = 0
+	case e.col > len(e.lines[e.row]):
+		e.col = len(e.lines[e.row])
+	}
+}
+
+// Scrolls so that the cursor row is within a window of the given height
+func (e *messageEditor) scroll(height int) {
+	if e.row < e.top {
+		e.top = e.row
+	}
+	if e.row >= e.top+height {
+		e.top = e.row - height + 1
+	}
+}
+
+func (e *messageEditor) draw(win *gc.Window) {
+	h, w := win.MaxYX()
+	e.scroll(h)
+	win.Erase()
+
+	for y := 0; y < h && e.top+y < len(e.lines); y++ {
+		row := e.top + y
+		line := e.lines[row]
+		limit := lineLimit(row)
+
+		for x, r := range line {
+			if x >= w {
+				break
+			}
+			if x >= limit {
+				win.ColorOn(3)
+			}
+			win.MovePrint(y, x, string(r))
+			if x >= limit {
+				win.ColorOff(3)
+			}
+		}
+		if len(line) < limit && limit < w {
+			win.AttrOn(gc.A_DIM)
+			win.MoveAddChar(y, limit, gc.ACS_VLINE)
+			win.AttrOff(gc.A_DIM)
+		}
+	}
+
+	x := e.col
+	if x >= w {
+		x = w - 1
+	}
+	win.Move(e.row-e.top, x)
+}
+
+// Shows a multi-line editor for message. Returns the edited message and
+// whether it was changed and confirmed.
+func editMessage(stdscr *gc.Window, message string) (string, bool) {
+	my, mx := stdscr.MaxYX()
+	h := my - 6
+	if h < 3 {
+		h = 3
+	}
+
+	win, err := gc.NewWindow(h+2, mx, 2, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer win.Delete()
+	win.Keypad(true)
+	dwin := win.Derived(h, mx-2, 1, 1)
+
+	title := "Edit Message"
+	help := "'F2' to keep changes, 'esc' to discard"
+
+	e := newMessageEditor(message)
+
+	for {
+		win.Erase()
+		win.ColorOn(1)
+		win.Box(0, 0)
+		win.ColorOff(1)
+		win.MovePrint(0, mx/2-len(title)/2, title)
+
+		status := fmt.Sprintf(" line %d, col %d - %s ", e.row+1, e.col+1, help)
+		if subject := len(e.lines[0]); subject > subjectLimit {
+			status = fmt.Sprintf(" subject %d/%d -%s", subject, subjectLimit, status)
+		}
+		win.MovePrint(h+1, 2, status)
+		win.NoutRefresh()
+
+		e.draw(dwin)
+		dwin.NoutRefresh()
+		gc.Update()
+
+		ch := win.GetChar()
+		switch ch {
+		case 27:
+			return message, false
+		case gc.KEY_F2:
+			if !e.modified {
+				return message, false
+			}
+			return e.message(), true
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			e.newline()
+		case gc.KEY_BACKSPACE, 127, 8:
+			e.backspace()
+		case gc.KEY_DC:
+			e.deleteChar()
+		case gc.KEY_LEFT:
+			e.moveCol(-1)
+		case gc.KEY_RIGHT:
+			e.moveCol(1)
+		case gc.KEY_UP:
+			e.moveRow(-1)
+		case gc.KEY_DOWN:
+			e.moveRow(1)
+		case gc.KEY_PAGEUP:
+			e.moveRow(-h)
+		case gc.KEY_PAGEDOWN:
+			e.moveRow(h)
+		case gc.KEY_HOME:
+			e.col = 0
+		case gc.KEY_END:
+			e.col = len(e.lines[e.row])
+		default:
+			if ch >= 32 && ch <= 255 {
+				e.inputByte(byte(ch))
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns an editor for subject with the cursor on a new body line
+func newBodyEditor(subject string) *messageEditor {
+	e := newMessageEditor(subject)
+	e.col = len(e.lines[0])
+	e.newline()
+	e.newline()
+	return e
+}
+
+func typeText(e *messageEditor, text string) {
+	for _, r := range text {
+		e.insert(r)
+	}
+}
+
+func TestMessageEditorWrap(t *testing.T) {
+	e := newBodyEditor("Subject")
+	words := strings.Repeat("word ", 20)
+	typeText(e, words)
+
+	for i, line := range e.lines[2:] {
+		if len(line) > bodyLimit {
+			t.Errorf("body line %d has %d characters: %q", i, len(line), string(line))
+		}
+	}
+	if got, exp := e.message(), "Subject\n\n"+strings.TrimSpace(strings.Repeat("word ", 14))+"\n"+strings.TrimSpace(strings.Repeat("word ", 6))+"\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+	if e.row != 3 || e.col != len(e.lines[3]) {
+		t.Errorf("cursor at %d:%d, want the end of the wrapped line", e.row, e.col)
+	}
+
+	// The subject is never wrapped
+	e = newMessageEditor("")
+	typeText(e, words)
+	if len(e.lines) != 1 {
+		t.Errorf("subject wrapped into %d lines", len(e.lines))
+	}
+
+	// Words longer than a line stay as they are
+	e = newBodyEditor("Subject")
+	typeText(e, strings.Repeat("x", bodyLimit+10))
+	if len(e.lines) != 3 {
+		t.Errorf("long word wrapped into %d lines", len(e.lines))
+	}
+}
+
+func TestMessageEditorBackspace(t *testing.T) {
+	e := newMessageEditor("Subject\n\nfirst\nsecond\n")
+	e.backspace()
+	if e.modified {
+		t.Error("backspace at the start of the message modified it")
+	}
+
+	// Joins the line with the previous one
+	e.row, e.col = 3, 0
+	e.backspace()
+	if got, exp := e.message(), "Subject\n\nfirstsecond\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+	if e.row != 2 || e.col != len("first") {
+		t.Errorf("cursor at %d:%d, want 2:5", e.row, e.col)
+	}
+
+	// Across the blank line into the subject
+	e.row, e.col = 2, 0
+	e.backspace()
+	e.backspace()
+	if got, exp := e.message(), "Subjectfirstsecond\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+	if !e.modified {
+		t.Error("not marked as modified")
+	}
+
+	e.backspace()
+	if got, exp := e.message(), "Subjecfirstsecond\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+}
+
+func TestMessageEditorMultiByte(t *testing.T) {
+	e := newMessageEditor("")
+	input := "Grüße 日本 🎉"
+	for i := 0; i < len(input); i++ {
+		e.inputByte(input[i])
+	}
+	if got := e.message(); got != input+"\n" {
+		t.Errorf("message() = %q, want %q", got, input+"\n")
+	}
+	if exp := len([]rune(input)); e.col != exp {
+		t.Errorf("cursor at column %d, want %d", e.col, exp)
+	}
+
+	// Backspace removes whole characters
+	e.backspace()
+	e.backspace()
+	if got, exp := e.message(), "Grüße 日本\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+
+	// Invalid bytes are dropped
+	for _, b := range []byte{0xff, 'a', 0xc3, 'b'} {
+		e.inputByte(b)
+	}
+	if got, exp := e.message(), "Grüße 日本ab\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+}
+
+func TestMessageEditorMessage(t *testing.T) {
+	e := newMessageEditor("Subject  \n\nbody \n\n\n")
+	if got, exp := e.message(), "Subject\n\nbody\n"; got != exp {
+		t.Errorf("message() = %q, want %q", got, exp)
+	}
+}
@@ -3,26 +3,54 @@ package main
 import (
 	"github.com/speedata/gogit"
 
+	"bytes"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
+	"strings"
+	"time"
 )
 
 type Repo struct {
 	repository *gogit.Repository
+
+	// The git directory holding HEAD, and the work tree ("" if bare)
+	gitDir   string
+	workTree string
+
+	// When set, rewrites only compute their plan and leave all refs alone
+	DryRun bool
+
+	// The ref to list and rewrite, see UseRef. Empty for the ref of HEAD.
+	Ref string
+
+	// Rewrite commits that remote-tracking refs contain without asking
+	AllowPublished bool
+
+	// List only first parents in commit logs, like git log --first-parent
+	FirstParent bool
+
+	// If set, called with the plan of every rewrite before anything is
+	// written. The rewrite is cancelled when it returns false.
+	Confirm func(*RewriteResult) bool
 }
 
 func isEqual(c1, c2 *gogit.Commit) bool {
 	return (c1.Oid == c2.Oid &&
 		c1.Author.Name == c2.Author.Name &&
 		c1.Author.Email == c2.Author.Email &&
-		c1.Author.When == c2.Author.When &&
+		sameTime(c1.Author.When, c2.Author.When) &&
 		c1.Committer.Name == c2.Committer.Name &&
 		c1.Committer.Email == c2.Committer.Email &&
-		c1.Committer.When == c2.Committer.When)
+		sameTime(c1.Committer.When, c2.Committer.When) &&
+		c1.CommitMessage == c2.CommitMessage)
+}
+
+// Returns a copy of ci that can be edited without touching ci
+func copyCommit(ci *gogit.Commit) *gogit.Commit {
+	dup := *ci
+	author, committer := *ci.Author, *ci.Committer
+	dup.Author, dup.Committer = &author, &committer
+	return &dup
 }
 
 func logCommit(ci *gogit.Commit) {
@@ -31,132 +59,406 @@ func logCommit(ci *gogit.Commit) {
 	log.Printf("Date          : %s\n", ci.Author.When)
 	log.Printf("Committer     : %s <%s>\n", ci.Committer.Name, ci.Committer.Email)
 	log.Printf("Committer Date: %s\n", ci.Committer.When)
+	log.Printf("Message       : %q\n", ci.CommitMessage)
 }
 
-func getCommitChainLength(ci *gogit.Commit, n int) int {
-	for i := 1; i < n; i++ {
-		ci = ci.Parent(0)
-		if ci.ParentCount() == 0 {
-			return i + 1
+// Returns the first line of the message of the commit oid, or of the commit
+// it tags if oid is an annotated tag. Returns an empty string for other
+// objects or if it cannot be read.
+func (r *Repo) subject(oid *gogit.Oid) string {
+	objectType, err := r.repository.Type(oid)
+	for err == nil && objectType == gogit.ObjectTag {
+		tag, tagErr := r.repository.LookupTag(oid)
+		if tagErr != nil {
+			return ""
 		}
+		oid = tag.TargetId
+		objectType, err = r.repository.Type(oid)
 	}
-
-	return n
+	if err != nil || objectType != gogit.ObjectCommit {
+		return ""
+	}
+	ci, err := r.repository.LookupCommit(oid)
+	if err != nil {
+		return ""
+	}
+	return strings.Split(ci.CommitMessage, "\n")[0]
 }
 
-func runGitGc() {
-	exec.Command("git", "gc")
-	return
+// A CommitLog lists the history of the target ref in topological order, or
+// only along first parents if the repo is set to. Commits are loaded on
+// demand, up to limit commits (0 for no limit) and not older than since (if
+// set).
+type CommitLog struct {
+	Commits []*gogit.Commit
+
+	// The full name of the listed ref, "HEAD" when detached
+	Ref string
+
+	// Set if only first parents are listed
+	FirstParent bool
+
+	// The width of the widest row of the graph
+	GraphWidth int
+
+	repo        *Repo
+	walker      *topoWalker
+	drawer      graphDrawer
+	graph       map[gogit.SHA1]string
+	decorations map[gogit.SHA1][]string
+	limit       int
+	since       time.Time
 }
 
-func OpenCurrentRepository() (*Repo, error) {
-	wd, err := os.Getwd()
+func (r *Repo) NewCommitLog(limit int, since time.Time) (*CommitLog, error) {
+	name, tip, err := r.resolveTarget()
 	if err != nil {
 		return nil, err
 	}
-
-	// Run git gc before running glt as speedata/gogit does not implement reading
-	// from Deltas
-	runGitGc()
-
-	repository, err := gogit.OpenRepository(filepath.Join(wd, "/.git"))
+	walker, err := r.newTopoWalker(tip, r.FirstParent)
+	if err != nil {
+		return nil, err
+	}
+	decorations, err := r.decorations()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Repo{
-		repository: repository,
+	return &CommitLog{
+		Ref:         name,
+		FirstParent: r.FirstParent,
+		repo:        r,
+		walker:      walker,
+		graph:       make(map[gogit.SHA1]string),
+		decorations: decorations,
+		limit:       limit,
+		since:       since,
 	}, nil
 }
 
+// Returns the row of the graph drawn for commit
+func (l *CommitLog) Graph(commit *gogit.Commit) string {
+	return l.graph[commit.Oid.Bytes]
+}
+
+// Returns the refs pointing at commit, e.g. "(HEAD -> main, tag: v1.0)", or
+// an empty string if there are none
+func (l *CommitLog) Decoration(commit *gogit.Commit) string {
+	names := l.decorations[commit.Oid.Bytes]
+	if len(names) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// Reports whether all commits of the log are loaded
+func (l *CommitLog) Done() bool {
+	return l.walker == nil || l.walker.Done()
+}
+
+// Loads up to n more commits and returns how many were added, reading no
+// more history than needed. Commits older than since are skipped, as merged
+// branches may bring in older commits before newer ones.
+func (l *CommitLog) LoadMore(n int) int {
+	loaded := 0
+	for loaded < n && !l.Done() {
+		if l.limit > 0 && len(l.Commits) >= l.limit {
+			l.walker = nil
+			break
+		}
+		ci, err := l.walker.Next()
+		if err != nil || ci == nil {
+			if err != nil {
+				log.Printf("Error reading history: %s", err)
+			}
+			l.walker = nil
+			break
+		}
+
+		row := l.drawer.row(ci.Oid, l.walker.parents(ci))
+		if !l.since.IsZero() && ci.Committer.When.Before(l.since) {
+			continue
+		}
+		l.graph[ci.Oid.Bytes] = row
+		if len(row) > l.GraphWidth {
+			l.GraphWidth = len(row)
+		}
+		l.Commits = append(l.Commits, ci)
+		loaded++
+	}
+	return loaded
+}
+
 func (r *Repo) GetLog(n int) ([]*gogit.Commit, error) {
-	ref, err := r.repository.LookupReference("HEAD")
+	commitLog, err := r.NewCommitLog(n, time.Time{})
 	if err != nil {
 		return nil, err
 	}
-	ci, err := r.repository.LookupCommit(ref.Oid)
-	if err != nil {
-		return nil, err
+	commitLog.LoadMore(n)
+	return commitLog.Commits, nil
+}
+
+func (r *Repo) IsDirty() bool {
+	if r.workTree == "" {
+		return false
 	}
+	output, _ := r.gitCommand("diff", "--shortstat").Output()
+	return len(bytes.TrimSpace(output)) > 0
+}
 
-	n = getCommitChainLength(ci, n)
+// A ref moved by a rewrite
+type RefUpdate struct {
+	Name string
+	Old  *gogit.Oid
+	New  *gogit.Oid
+}
 
-	commitList := make([]*gogit.Commit, n)
-	commitList[0] = ci
+// The outcome of a rewrite: the moved refs, the backup of their old tips and
+// the ids of every commit that was replaced. Ref, OldTip and NewTip describe
+// the first moved ref, which is the target branch if it moved.
+type RewriteResult struct {
+	Ref    string
+	OldTip *gogit.Oid
+	NewTip *gogit.Oid
+	Refs   []*RefUpdate
+	Backup string
 
-	for i := 1; i < n; i++ {
-		ci = ci.Parent(0)
-		if ci == nil {
-			break
+	// Annotated tags that were rewritten without their signature
+	UnsignedTags []string
+
+	// Edited commits that are already on a remote
+	Published []*PublishedCommit
+
+	// Set for dry runs, in which no object or ref was written
+	DryRun bool
+	Plan   []*PlannedCommit
+
+	rewritten map[gogit.SHA1]*gogit.Oid
+}
+
+// Returns the names of all moved refs, separated by commas
+func (res *RewriteResult) RefNames() string {
+	names := make([]string, len(res.Refs))
+	for i, ref := range res.Refs {
+		names[i] = ref.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the id that replaced oid, or oid itself if it was not rewritten
+func (res *RewriteResult) NewId(oid *gogit.Oid) *gogit.Oid {
+	if newOid, ok := res.rewritten[oid.Bytes]; ok {
+		return newOid
+	}
+	return oid
+}
+
+// Returns the result of the change and error. The result is nil when the
+// commit was not modified.
+func (r *Repo) SaveCommitIfModified(commit *gogit.Commit) (*RewriteResult, error) {
+	return r.SaveCommitsIfModified([]*gogit.Commit{commit})
+}
+
+// Saves those of commits that were modified in a single rewrite. The result
+// is nil when none of them was.
+func (r *Repo) SaveCommitsIfModified(commits []*gogit.Commit) (*RewriteResult, error) {
+	var modified []*gogit.Commit
+	for _, commit := range commits {
+		original, err := r.repository.LookupCommit(commit.Oid)
+		if err != nil {
+			return nil, fmt.Errorf("Error finding matching commit: %s", err)
+		}
+		if !isEqual(commit, original) {
+			modified = append(modified, commit)
 		}
-		commitList[i] = ci
 	}
 
-	return commitList, nil
+	if len(modified) == 0 {
+		log.Println("Before and after are equal, not saving.")
+		return nil, nil
+	}
+	return r.SaveCommits(modified)
 }
 
-func (r *Repo) IsDirty() bool {
-	gitCmd := `[[ $(git diff --shortstat 2> /dev/null | tail -n1) != "" ]] && echo "dirty"`
-	cmd := exec.Command("bash", "-c", gitCmd)
-	output, _ := cmd.Output()
+// Rewrites commit and all of its descendants, then moves the branches
+// containing it to the rewritten tips.
+func (r *Repo) SaveCommit(commit *gogit.Commit) (*RewriteResult, error) {
+	return r.SaveCommits([]*gogit.Commit{commit})
+}
+
+// Lists the refs a rewrite may move: the target ref, a detached HEAD,
+// every local branch and every lightweight tag. Annotated tags are handled
+// by rewriteTags.
+func (r *Repo) rewriteCandidates() ([]*gogit.Reference, error) {
+	name, tip, err := r.resolveTarget()
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving the branch to rewrite: %s", err)
+	}
+	refs := []*gogit.Reference{{Name: name, Oid: tip}}
 
-	re := regexp.MustCompile("dirty")
-	return re.Match(output)
+	if headName, head, err := r.resolveHead(); err == nil && headName == "HEAD" && name != "HEAD" {
+		refs = append(refs, &gogit.Reference{Name: headName, Oid: head})
+	}
+
+	heads, err := r.listRefs("refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := r.listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range append(heads, tags...) {
+		if ref.Name == name {
+			continue
+		}
+		if objectType, err := r.repository.Type(ref.Oid); err != nil || objectType != gogit.ObjectCommit {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// Sets the Old value of every ref update of result to where the ref was in
+// plan. Fails if the rewrite moves other refs than plan.
+func expectPlannedRefs(result, plan *RewriteResult) error {
+	if len(result.Refs) != len(plan.Refs) {
+		return fmt.Errorf("Refs changed while the rewrite was being confirmed, aborting without changes")
+	}
+	planned := make(map[string]*gogit.Oid)
+	for _, ref := range plan.Refs {
+		planned[ref.Name] = ref.Old
+	}
+	for _, ref := range result.Refs {
+		old, ok := planned[ref.Name]
+		if !ok {
+			return fmt.Errorf("%s changed while the rewrite was being confirmed, aborting without changes", ref.Name)
+		}
+		ref.Old = old
+	}
+	return nil
 }
 
-// Returns the ref of change and error
-func (r *Repo) SaveCommitIfModified(commit *gogit.Commit) (string, error) {
-	original, err := r.repository.LookupCommit(commit.Oid)
+// Rewrites commits and moves every candidate ref and annotated tag that
+// contains one of them. In a dry run nothing is written.
+func (r *Repo) rewriteRefs(commits []*gogit.Commit, dryRun bool) (*RewriteResult, error) {
+	candidates, err := r.rewriteCandidates()
 	if err != nil {
-		return "", fmt.Errorf("Error finding matching commit: %s", err)
+		return nil, err
 	}
 
-	if !isEqual(commit, original) {
-		return r.SaveCommit(commit)
-	} else {
-		log.Println("Before and after are equal, not saving.")
+	rw := newRewriter(r)
+	rw.dryRun = dryRun
+	for _, commit := range commits {
+		rw.edit(commit)
+	}
+
+	result := &RewriteResult{DryRun: dryRun, rewritten: rw.rewritten}
+	for _, ref := range candidates {
+		newTip, err := rw.rewrite(ref.Oid)
+		if err != nil {
+			return nil, err
+		}
+		if !newTip.Equal(ref.Oid) {
+			result.Refs = append(result.Refs, &RefUpdate{Name: ref.Name, Old: ref.Oid, New: newTip})
+		}
+	}
+	tags, unsigned, err := r.rewriteTags(rw)
+	if err != nil {
+		return nil, err
+	}
+	result.Refs = append(result.Refs, tags...)
+	result.UnsignedTags = unsigned
+	if len(result.Refs) == 0 {
+		return nil, fmt.Errorf("Git rewrite failed due to no change")
 	}
+	result.Ref, result.OldTip, result.NewTip = result.Refs[0].Name, result.Refs[0].Old, result.Refs[0].New
 
-	return "", nil
+	if result.Plan, err = r.planOf(rw); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (r *Repo) SaveCommit(commit *gogit.Commit) (string, error) {
-	scope := ""
-	if commit.Parent(0) != nil {
-		scope = fmt.Sprintf("%s..HEAD", commit.Parent(0).Oid.String())
+// Rewrites several commits and their descendants in a single pass, then
+// moves every local branch and tag containing them. Commits that are on a
+// remote are only rewritten if confirmed or AllowPublished is set. Returns a
+// nil result if the rewrite was not confirmed.
+func (r *Repo) SaveCommits(commits []*gogit.Commit) (*RewriteResult, error) {
+	for _, commit := range commits {
+		if err := checkSignature("Author", commit.Author); err != nil {
+			return nil, err
+		}
+		if err := checkSignature("Committer", commit.Committer); err != nil {
+			return nil, err
+		}
 	}
 
-	gitCmd := fmt.Sprintf(`git filter-branch --env-filter 'if [ $GIT_COMMIT = %s ]
-		then
-			export GIT_AUTHOR_NAME="%s" &&
-			export GIT_AUTHOR_EMAIL="%s" &&
-			export GIT_AUTHOR_DATE="%s" &&
-			export GIT_COMMITTER_NAME="%s" &&
-			export GIT_COMMITTER_EMAIL="%s" &&
-			export GIT_COMMITTER_DATE="%s"; fi' %s &&
-		rm -fr "$(git rev-parse --git-dir)/refs/original/"`,
-		commit.Oid.String(),
-		commit.Author.Name,
-		commit.Author.Email,
-		commit.Author.When.String(),
-		commit.Committer.Name,
-		commit.Committer.Email,
-		commit.Committer.When.String(),
-		scope)
-	log.Println(gitCmd)
-	cmd := exec.Command("bash", "-c", gitCmd)
+	var published []*PublishedCommit
+	var err error
+	if !r.AllowPublished {
+		if published, err = r.publishedCommits(commits); err != nil {
+			return nil, fmt.Errorf("Error checking remote-tracking refs: %s", err)
+		}
+	}
+	if len(published) > 0 && !r.AllowPublished && !r.DryRun && r.Confirm == nil {
+		first := published[0]
+		return nil, fmt.Errorf("Commit %s is already on %s, pass --allow-published to rewrite it anyway",
+			first.Oid.String()[:12], strings.Join(first.Remotes, ", "))
+	}
 
-	output, err := cmd.Output()
-	re := regexp.MustCompile("Ref '([^']*)' was rewritten")
-	match := re.FindStringSubmatch(string(output))
+	var plan *RewriteResult
+	if r.DryRun || r.Confirm != nil {
+		plan, err = r.rewriteRefs(commits, true)
+		if err != nil {
+			return nil, err
+		}
+		plan.Published = published
+		if r.DryRun {
+			for _, ref := range plan.Refs {
+				log.Printf("Dry run, %s would move: %s -> %s", ref.Name, ref.Old, ref.New)
+			}
+			return plan, nil
+		}
+		if !r.Confirm(plan) {
+			log.Println("Rewrite not confirmed, not saving.")
+			return nil, nil
+		}
+	}
 
+	result, err := r.rewriteRefs(commits, false)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	result.Published = published
+	if plan != nil {
+		// Refs are only moved from where they were when confirmed
+		if err := expectPlannedRefs(result, plan); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(match) == 0 {
-		return "", fmt.Errorf("Git rewrite failed due to no change")
-	} else {
-		return match[1], nil
+	for _, published := range result.Published {
+		log.Printf("Rewriting %s which is on %s", published.Oid, strings.Join(published.Remotes, ", "))
+	}
+	for _, name := range result.UnsignedTags {
+		log.Printf("Dropped the signature of %s", name)
+	}
+	// Nothing is moved if a ref changed since it was read
+	tx, err := r.lockRefs(result.Refs)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range result.Refs {
+		log.Printf("Rewriting %s: %s -> %s", ref.Name, ref.Old, ref.New)
+	}
+	if result.Backup, err = r.createBackup(result.Refs); err != nil {
+		tx.rollback()
+		return nil, err
+	}
+	if err := tx.commit(reflogMessage(result)); err != nil {
+		return nil, err
 	}
+	return result, nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"strings"
+)
+
+// Lines of history wider than this are cut off in the graph
+const maxGraphLanes = 10
+
+// Draws an ASCII graph one row per commit, a compact form of git log
+// --graph: '*' is the commit, '|' another line of history, '/' a line that
+// ends in the commit and '\' a line started by a merge. Rows have to be
+// drawn in the order the commits are listed.
+type graphDrawer struct {
+	// The commit each line of history continues with
+	lanes []gogit.SHA1
+}
+
+// Returns the row of the commit oid with parents
+func (g *graphDrawer) row(oid *gogit.Oid, parents []*gogit.Oid) string {
+	col := -1
+	for j, lane := range g.lanes {
+		if lane == oid.Bytes {
+			col = j
+			break
+		}
+	}
+	if col < 0 {
+		g.lanes = append(g.lanes, oid.Bytes)
+		col = len(g.lanes) - 1
+	}
+
+	var row bytes.Buffer
+	var next []gogit.SHA1
+	for j, lane := range g.lanes {
+		switch {
+		case j == col:
+			row.WriteString("* ")
+			if len(parents) > 0 {
+				next = append(next, parents[0].Bytes)
+			}
+		case lane == oid.Bytes:
+			row.WriteString("/ ")
+		default:
+			row.WriteString("| ")
+			next = append(next, lane)
+		}
+	}
+	for k := 1; k < len(parents); k++ {
+		tracked := false
+		for _, lane := range next {
+			if lane == parents[k].Bytes {
+				tracked = true
+			}
+		}
+		if !tracked {
+			row.WriteString("\\ ")
+			next = append(next, parents[k].Bytes)
+		}
+	}
+
+	line := strings.TrimRight(row.String(), " ")
+	if len(line) > 2*maxGraphLanes {
+		line = line[:2*maxGraphLanes-2] + ".."
+	}
+	g.lanes = next
+	return line
+}
@@ -10,15 +10,712 @@ import (
 	"time"
 )
 
-func selectCommit(stdscr *gc.Window, commits []*gogit.Commit) *gogit.Commit {
+// What to do with the commit picked in selectCommit
+type menuAction int
+
+const (
+	actionQuit menuAction = iota
+	actionEdit
+	actionReplaceIdentity
+	actionBackups
+	actionBranches
+	actionTags
+	actionFirstParent
+)
+
+func commitItem(commitLog *CommitLog, commit *gogit.Commit, messageLength int) *gc.MenuItem {
+	graph := commitLog.Graph(commit)
+	label := " " + graph + strings.Repeat(" ", commitLog.GraphWidth-len(graph)+1) + commit.Oid.String()[:16]
+
+	// Get first line and trim description characters
+	trimMessage := strings.Split(commit.CommitMessage, "\n")[0]
+	if commit.ParentCount() > 1 {
+		trimMessage = "[merge] " + trimMessage
+	}
+	if decoration := commitLog.Decoration(commit); decoration != "" {
+		trimMessage = decoration + " " + trimMessage
+	}
+	messageLength -= commitLog.GraphWidth + 1
+	if messageLength < 4 {
+		messageLength = 4
+	}
+	if len(trimMessage) > messageLength {
+		trimMessage = trimMessage[:messageLength-2] + ".."
+	}
+	desc := commit.Committer.When.String()[5:19] + " - " + trimMessage
+
+	item, _ := gc.NewItem(label, desc)
+	return item
+}
+
+// Reports whether commit matches a search query by SHA prefix, subject,
+// author name or email, or date
+func matchesQuery(commit *gogit.Commit, query string) bool {
+	query = strings.ToLower(query)
+	if strings.HasPrefix(commit.Oid.String(), query) {
+		return true
+	}
+	subject := strings.Split(commit.CommitMessage, "\n")[0]
+	for _, field := range []string{
+		subject,
+		commit.Author.Name,
+		commit.Author.Email,
+		commit.Author.When.String(),
+		commit.Committer.When.String(),
+	} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lets the user pick commits to act on. Commits marked with space are
+// returned for editing together, otherwise the current one is returned.
+func selectCommit(stdscr *gc.Window, commitLog *CommitLog) ([]*gogit.Commit, menuAction) {
+	if len(commitLog.Commits) == 0 {
+		showMessage(stdscr, "No commits to show.")
+		return nil, actionQuit
+	}
+
 	stdscr.Clear()
-	_, mx := stdscr.MaxYX()
+	my, mx := stdscr.MaxYX()
 	title := "Welcome to GLT!"
+	help := "'enter' edit, 'space' mark, 'i' identity, 'r' branch, 't' tags, 'b' backups, '/' search, 'esc' exit"
+	moreHelp := "'d' commit details, 'f' toggle first parent history"
 	stdscr.MovePrint(1, mx/2-len(title)/2, title)
-	stdscr.MovePrint(16, 1, "'esc' to exit")
+	stdscr.MovePrint(my-2, 1, help)
+	stdscr.MovePrint(my-1, 1, moreHelp)
 	stdscr.Keypad(true)
 
-	win, err := gc.NewWindow(12, mx, 3, 0)
+	rows := my - 7
+	if rows < 3 {
+		rows = 3
+	}
+	win, err := gc.NewWindow(rows+2, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	win.Keypad(true)
+	dwin := win.Derived(rows, mx-2, 1, 1)
+
+	// calculate remainder length for commit message
+	messageLength := mx - 41
+
+	// One item per loaded commit, the menu shows those matching query
+	var items []*gc.MenuItem
+	var visible []int
+	query := ""
+	defer func() {
+		for _, item := range items {
+			item.Free()
+		}
+	}()
+	for _, commit := range commitLog.Commits {
+		items = append(items, commitItem(commitLog, commit, messageLength))
+		visible = append(visible, len(visible))
+	}
+	graphWidth := commitLog.GraphWidth
+
+	menu, err := gc.NewMenu(items)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	menu.Option(gc.O_ONEVALUE, false)
+	menu.SetPad('-')
+	menu.SetSpacing(3, 1, 1)
+	menu.Format(rows, 1)
+	menu.SubWindow(dwin)
+	menu.Post()
+	defer menu.UnPost()
+	defer menu.Free()
+
+	// Returns the marked commits, newest first
+	marked := func() []*gogit.Commit {
+		var commits []*gogit.Commit
+		for i, item := range items {
+			if item.Value() {
+				commits = append(commits, commitLog.Commits[i])
+			}
+		}
+		return commits
+	}
+
+	drawBox := func() {
+		win.ColorOn(2)
+		win.Box(0, 0)
+		more := ""
+		if !commitLog.Done() {
+			more = "+"
+		}
+		status := fmt.Sprintf(" %d%s commits ", len(items), more)
+		if marked := len(marked()); marked > 0 {
+			status = fmt.Sprintf(" %d marked,%s", marked, status)
+		}
+		if query != "" {
+			matches := "matches"
+			if len(visible) == 1 {
+				matches = "match"
+			}
+			status = fmt.Sprintf(" %d %s for '%s' in %d%s commits ", len(visible), matches, query, len(items), more)
+		}
+		win.MovePrint(rows+1, mx-len(status)-2, status)
+		title := " " + shortRefName(commitLog.Ref) + " "
+		if commitLog.FirstParent {
+			title += "(first parent) "
+		}
+		win.MovePrint(0, 2, title)
+		win.ColorOff(2)
+	}
+	drawBox()
+
+	// Returns the index of the selected commit, or -1 if nothing is shown
+	selected := func() int {
+		if len(visible) == 0 {
+			return -1
+		}
+		return visible[menu.Current(nil).Index()]
+	}
+
+	// Shows the loaded commits matching query and selects the shown commit
+	// closest to (at or before) the commit at index selection
+	show := func(selection int) {
+		if commitLog.GraphWidth != graphWidth {
+			// Newly loaded commits widened the graph, line the others up
+			// with them. The old items can only be freed once the menu no
+			// longer holds them.
+			old := items
+			items = make([]*gc.MenuItem, len(old))
+			for i, item := range old {
+				items[i] = commitItem(commitLog, commitLog.Commits[i], messageLength)
+				items[i].SetValue(item.Value())
+			}
+			menu.UnPost()
+			menu.SetItems(items)
+			for _, item := range old {
+				item.Free()
+			}
+			graphWidth = commitLog.GraphWidth
+		}
+		for _, commit := range commitLog.Commits[len(items):] {
+			items = append(items, commitItem(commitLog, commit, messageLength))
+		}
+
+		visible = visible[:0]
+		current := 0
+		for i, commit := range commitLog.Commits {
+			if query != "" && !matchesQuery(commit, query) {
+				continue
+			}
+			if i <= selection {
+				current = len(visible)
+			}
+			visible = append(visible, i)
+		}
+
+		menu.UnPost()
+		dwin.Erase()
+		if len(visible) == 0 {
+			dwin.MovePrint(0, 1, "No matches")
+		} else {
+			shown := make([]*gc.MenuItem, len(visible))
+			for i, index := range visible {
+				shown[i] = items[index]
+			}
+			// Formatting the menu clears the marks of its items
+			marks := make([]bool, len(items))
+			for i, item := range items {
+				marks[i] = item.Value()
+			}
+			menu.SetItems(shown)
+			menu.Format(rows, 1)
+			menu.Post()
+			for i, item := range items {
+				item.SetValue(marks[i])
+			}
+			menu.Current(shown[current])
+		}
+		drawBox()
+	}
+
+	// Loads up to n more commits into the menu, keeping the selection.
+	// Returns false when there was nothing left to load.
+	loadMore := func(n int) bool {
+		if commitLog.Done() || commitLog.LoadMore(n) == 0 {
+			drawBox()
+			return false
+		}
+		show(selected())
+		return true
+	}
+
+	// Reads a search query on the help line, filtering the menu while typing
+	search := func() {
+		selection := selected()
+		previous := query
+		query = ""
+		for {
+			stdscr.Move(my-2, 0)
+			stdscr.ClearToEOL()
+			stdscr.MovePrint(my-2, 1, "/"+query)
+			stdscr.Refresh()
+			show(selection)
+			win.Refresh()
+
+			switch ch := win.GetChar(); ch {
+			case 27:
+				query = previous
+				show(selection)
+				stdscr.MovePrint(my-2, 1, help)
+				return
+			case gc.KEY_ENTER, gc.KEY_RETURN:
+				stdscr.Move(my-2, 0)
+				stdscr.ClearToEOL()
+				stdscr.MovePrint(my-2, 1, "'n'/'N' for next/previous match, 'esc' to clear the search")
+				return
+			case gc.KEY_BACKSPACE, 127, 8:
+				if len(query) > 0 {
+					query = query[:len(query)-1]
+				}
+			default:
+				if ch >= 32 && ch < 127 {
+					query += string(rune(ch))
+				}
+			}
+		}
+	}
+
+	stdscr.Refresh()
+	win.Refresh()
+
+	for {
+		stdscr.Refresh()
+		gc.Update()
+		ch := win.GetChar()
+		index := selected()
+
+		switch ch {
+		case 27:
+			if query == "" {
+				return nil, actionQuit
+			}
+			query = ""
+			show(index)
+			stdscr.Move(my-2, 0)
+			stdscr.ClearToEOL()
+			stdscr.MovePrint(my-2, 1, help)
+		case '/':
+			search()
+		case 'b':
+			return nil, actionBackups
+		case 'r':
+			return nil, actionBranches
+		case 't':
+			return nil, actionTags
+		case 'f':
+			return nil, actionFirstParent
+		}
+
+		if index < 0 {
+			continue
+		}
+		position := menu.Current(nil).Index()
+
+		switch ch {
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			if commits := marked(); len(commits) > 0 {
+				return commits, actionEdit
+			}
+			return []*gogit.Commit{commitLog.Commits[index]}, actionEdit
+		case ' ':
+			menu.Driver(gc.REQ_TOGGLE)
+			drawBox()
+		case 'i':
+			return []*gogit.Commit{commitLog.Commits[index]}, actionReplaceIdentity
+		case 'd':
+			showCommitDetail(stdscr, commitLog, commitLog.Commits[index])
+			stdscr.Clear()
+			stdscr.MovePrint(1, mx/2-len(title)/2, title)
+			stdscr.MovePrint(my-2, 1, help)
+			stdscr.MovePrint(my-1, 1, moreHelp)
+			stdscr.Refresh()
+			win.Touch()
+			drawBox()
+		case 'n':
+			if query == "" {
+				break
+			}
+			if position < len(visible)-1 {
+				menu.Driver(gc.REQ_DOWN)
+				break
+			}
+			// Search further back in history, wrap around if nothing is found
+			count := len(visible)
+			for len(visible) == count && loadMore(rows) {
+			}
+			if len(visible) > count {
+				menu.Current(menu.Items()[count])
+			} else {
+				menu.Driver(gc.REQ_FIRST)
+			}
+		case 'N':
+			if query == "" {
+				break
+			}
+			if position > 0 {
+				menu.Driver(gc.REQ_UP)
+			} else {
+				menu.Driver(gc.REQ_LAST)
+			}
+		case gc.KEY_DOWN:
+			if position == len(visible)-1 {
+				loadMore(rows)
+			}
+			menu.Driver(gc.REQ_DOWN)
+		case gc.KEY_UP:
+			menu.Driver(gc.REQ_UP)
+		case gc.KEY_PAGEDOWN:
+			if position+rows >= len(visible) {
+				loadMore(rows)
+			}
+			if menu.Driver(gc.REQ_PAGE_DOWN) != nil {
+				menu.Driver(gc.REQ_LAST)
+			}
+		case gc.KEY_PAGEUP:
+			if menu.Driver(gc.REQ_PAGE_UP) != nil {
+				menu.Driver(gc.REQ_FIRST)
+			}
+		case gc.KEY_HOME:
+			menu.Driver(gc.REQ_FIRST)
+		case gc.KEY_END:
+			for loadMore(10 * rows) {
+			}
+			menu.Driver(gc.REQ_LAST)
+		}
+		win.Refresh()
+	}
+}
+
+// Longest value the fields of the edit forms hold. Fields scroll
+// horizontally once a value is longer than they are wide.
+const fieldMaxLength = 256
+
+// The editable fields of a commit, in form order. set returns an error if
+// the value cannot be applied.
+var commitFields = []struct {
+	label string
+	get   func(*gogit.Commit) string
+	set   func(*gogit.Commit, string) error
+}{
+	{"Author Name     :",
+		func(c *gogit.Commit) string { return c.Author.Name },
+		func(c *gogit.Commit, value string) error {
+			if err := checkName(value); err != nil {
+				return err
+			}
+			c.Author.Name = value
+			return nil
+		}},
+	{"Author Email    :",
+		func(c *gogit.Commit) string { return c.Author.Email },
+		func(c *gogit.Commit, value string) error {
+			if err := checkEmail(value); err != nil {
+				return err
+			}
+			c.Author.Email = value
+			return nil
+		}},
+	{"Author Date     :",
+		func(c *gogit.Commit) string { return c.Author.When.Format(localDateLayout) },
+		func(c *gogit.Commit, value string) (err error) {
+			c.Author.When, err = setDate(c.Author.When, value)
+			return err
+		}},
+	{"Author Offset   :",
+		func(c *gogit.Commit) string { return c.Author.When.Format(offsetLayout) },
+		func(c *gogit.Commit, value string) (err error) {
+			c.Author.When, err = setOffset(c.Author.When, value)
+			return err
+		}},
+	{"Committer Name  :",
+		func(c *gogit.Commit) string { return c.Committer.Name },
+		func(c *gogit.Commit, value string) error {
+			if err := checkName(value); err != nil {
+				return err
+			}
+			c.Committer.Name = value
+			return nil
+		}},
+	{"Committer Email :",
+		func(c *gogit.Commit) string { return c.Committer.Email },
+		func(c *gogit.Commit, value string) error {
+			if err := checkEmail(value); err != nil {
+				return err
+			}
+			c.Committer.Email = value
+			return nil
+		}},
+	{"Committer Date  :",
+		func(c *gogit.Commit) string { return c.Committer.When.Format(localDateLayout) },
+		func(c *gogit.Commit, value string) (err error) {
+			c.Committer.When, err = setDate(c.Committer.When, value)
+			return err
+		}},
+	{"Committer Offset:",
+		func(c *gogit.Commit) string { return c.Committer.When.Format(offsetLayout) },
+		func(c *gogit.Commit, value string) (err error) {
+			c.Committer.When, err = setOffset(c.Committer.When, value)
+			return err
+		}},
+}
+
+func setDate(when time.Time, value string) (time.Time, error) {
+	t, err := parseDate(value, when)
+	if err != nil {
+		return when, err
+	}
+	return t, nil
+}
+
+func setOffset(when time.Time, value string) (time.Time, error) {
+	loc, err := parseOffset(value)
+	if err != nil {
+		return when, err
+	}
+	return withOffset(when, loc), nil
+}
+
+// Edits one or more commits in a single form. Fields that differ across the
+// commits start out empty, and only fields the user changed are applied to
+// every commit. Returns nil if the user cancelled.
+func editCommits(stdscr *gc.Window, commits []*gogit.Commit) []*gogit.Commit {
+	stdscr.Clear()
+	_, mx := stdscr.MaxYX()
+	title := fmt.Sprintf("Edit Commit %s", commits[0].Oid.String())
+	if len(commits) > 1 {
+		title = fmt.Sprintf("Edit %d Commits", len(commits))
+	}
+	height := len(commitFields) + 4
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(height+6, 1, "'enter' to save, 'F2' to edit message, 'esc' to exit")
+	stdscr.Keypad(true)
+
+	win, err := gc.NewWindow(height+2, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dwin := win.Derived(height, mx-2, 1, 1)
+	win.Keypad(true)
+	win.ColorOn(1)
+	win.Box(0, 0)
+	win.ColorOff(1)
+
+	// The value shared by all commits, or "" where they differ
+	initial := make([]string, len(commitFields))
+	differs := make([]bool, len(commitFields))
+	for i, field := range commitFields {
+		initial[i] = field.get(commits[0])
+		for _, commit := range commits[1:] {
+			if field.get(commit) != initial[i] {
+				initial[i], differs[i] = "", true
+				break
+			}
+		}
+	}
+	message := commits[0].CommitMessage
+	messageDiffers := false
+	for _, commit := range commits[1:] {
+		if commit.CommitMessage != message {
+			message, messageDiffers = "", true
+			break
+		}
+	}
+	messageChanged := false
+
+	fields := make([]*gc.Field, len(commitFields))
+	for i := range commitFields {
+		fields[i], _ = gc.NewField(1, 32, int32(i), 19, 0, 0)
+		defer fields[i].Free()
+		fields[i].SetForeground(gc.ColorPair(3))
+		fields[i].SetBackground(gc.ColorPair(3) | gc.A_UNDERLINE | gc.A_BOLD)
+		fields[i].SetOptionsOff(gc.FO_AUTOSKIP | gc.FO_STATIC)
+		fields[i].SetMax(fieldMaxLength)
+		fields[i].SetBuffer(initial[i])
+		// Compare edits with what the field holds, should a value not fit
+		initial[i] = strings.TrimSpace(fields[i].Buffer())
+	}
+
+	form, _ := gc.NewForm(fields)
+	form.SetWindow(win)
+	form.SetSub(dwin)
+	form.Post()
+	defer form.UnPost()
+	defer form.Free()
+
+	for i, field := range commitFields {
+		dwin.MovePrint(i, 1, field.label)
+	}
+
+	messageLength := mx - 4
+	printMessage := func() {
+		var trimMessage string
+		if messageDiffers && !messageChanged {
+			trimMessage = fmt.Sprintf("Message: (differs across %d commits)", len(commits))
+		} else {
+			lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+			trimMessage = fmt.Sprintf("Message: %s", lines[0])
+			if len(lines) > 1 {
+				trimMessage += fmt.Sprintf(" (+%d lines)", len(lines)-1)
+			}
+		}
+		if len(trimMessage) > messageLength {
+			trimMessage = trimMessage[:messageLength-2] + ".."
+		}
+		dwin.Move(len(commitFields)+1, 1)
+		dwin.ClearToEOL()
+		dwin.MovePrint(len(commitFields)+1, 1, trimMessage)
+	}
+	printMessage()
+
+	// Applies the changed fields to copies of the commits. Errors are shown
+	// beside their field and valid values are re-displayed normalized.
+	// Returns the edited copies, or nil if any field is invalid.
+	noteLength := mx - 2 - 53 - 1
+	check := func() []*gogit.Commit {
+		edited := make([]*gogit.Commit, len(commits))
+		for i, commit := range commits {
+			edited[i] = copyCommit(commit)
+		}
+		errs := make([]error, len(commitFields))
+		for i, field := range commitFields {
+			value := strings.TrimSpace(fields[i].Buffer())
+			if value == strings.TrimSpace(initial[i]) {
+				continue
+			}
+			for _, commit := range edited {
+				if err := field.set(commit, value); err != nil && errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+
+		valid := true
+		for i, field := range commitFields {
+			note := ""
+			fields[i].SetBackground(gc.ColorPair(3) | gc.A_UNDERLINE | gc.A_BOLD)
+			if errs[i] != nil {
+				valid = false
+				note = errs[i].Error()
+				fields[i].SetBackground(gc.ColorPair(3) | gc.A_REVERSE | gc.A_BOLD)
+			} else if differs[i] {
+				note = "(differs)"
+			} else if value := field.get(edited[0]); value != strings.TrimSpace(fields[i].Buffer()) {
+				fields[i].SetBuffer(value)
+			}
+			if len(note) > noteLength {
+				note = note[:noteLength-2] + ".."
+			}
+			dwin.Move(i, 53)
+			dwin.ClearToEOL()
+			if errs[i] != nil {
+				dwin.ColorOn(3)
+				dwin.MovePrint(i, 53, note)
+				dwin.ColorOff(3)
+			} else {
+				dwin.MovePrint(i, 53, note)
+			}
+		}
+
+		dwin.Move(len(commitFields)+3, 1)
+		dwin.ClearToEOL()
+		if !valid {
+			return nil
+		}
+		return edited
+	}
+	check()
+
+	stdscr.Refresh()
+	win.Refresh()
+
+	form.Driver(gc.REQ_FIRST_FIELD)
+
+	ch := win.GetChar()
+	for ch != 27 {
+		switch ch {
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			form.Driver(gc.REQ_VALIDATION)
+
+			edited := check()
+			if edited == nil {
+				dwin.ColorOn(3)
+				dwin.MovePrint(len(commitFields)+3, 1, "Fix the highlighted fields to save.")
+				dwin.ColorOff(3)
+				break
+			}
+			for i, commit := range commits {
+				*commit = *edited[i]
+				if messageChanged {
+					commit.CommitMessage = message
+				}
+			}
+
+			return commits
+		case gc.KEY_F2:
+			if edited, ok := editMessage(stdscr, message); ok && edited != message {
+				message, messageChanged = edited, true
+				printMessage()
+			}
+			stdscr.Touch()
+			stdscr.Refresh()
+			win.Touch()
+			win.Refresh()
+			form.Driver(gc.REQ_FIRST_FIELD)
+		case gc.KEY_LEFT:
+			form.Driver(gc.REQ_PREV_CHAR)
+		case gc.KEY_RIGHT:
+			form.Driver(gc.REQ_NEXT_CHAR)
+		case gc.KEY_DOWN, gc.KEY_TAB:
+			form.Driver(gc.REQ_VALIDATION)
+			check()
+			form.Driver(gc.REQ_NEXT_FIELD)
+		case gc.KEY_UP:
+			form.Driver(gc.REQ_VALIDATION)
+			check()
+			form.Driver(gc.REQ_PREV_FIELD)
+		case gc.KEY_BACKSPACE, 127:
+			form.Driver(gc.REQ_DEL_PREV)
+		case gc.KEY_DC:
+			form.Driver(gc.REQ_DEL_CHAR)
+		case gc.KEY_HOME:
+			form.Driver(gc.REQ_BEG_FIELD)
+		case gc.KEY_END:
+			form.Driver(gc.REQ_END_FIELD)
+		default:
+			form.Driver(ch)
+		}
+		win.Refresh()
+		ch = stdscr.GetChar()
+	}
+
+	return nil
+}
+
+// Lets the user pick one of branches, starting at the current one
+func selectBranch(stdscr *gc.Window, branches []*gogit.Reference, current string) *gogit.Reference {
+	stdscr.Clear()
+	my, mx := stdscr.MaxYX()
+	title := "Branches"
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(my-2, 1, "'enter' to edit the branch, 'esc' to go back")
+	stdscr.Keypad(true)
+
+	rows := my - 7
+	if rows < 3 {
+		rows = 3
+	}
+	win, err := gc.NewWindow(rows+2, mx, 3, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -26,23 +723,375 @@ func selectCommit(stdscr *gc.Window, commits []*gogit.Commit) *gogit.Commit {
 	win.ColorOn(2)
 	win.Box(0, 0)
 	win.ColorOff(2)
-	dwin := win.Derived(10, mx-2, 1, 1)
+	dwin := win.Derived(rows, mx-2, 1, 1)
 
-	// calculate remainder length for commit message
-	messageLength := mx - 41
+	items := make([]*gc.MenuItem, len(branches))
+	for i, branch := range branches {
+		items[i], _ = gc.NewItem(" "+shortRefName(branch.Name), branch.Oid.String()[:12])
+		defer items[i].Free()
+	}
+
+	menu, err := gc.NewMenu(items)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	menu.SetPad('-')
+	menu.SetSpacing(3, 1, 1)
+	menu.Format(rows, 1)
+	menu.SubWindow(dwin)
+	menu.Post()
+	defer menu.UnPost()
+	defer menu.Free()
+
+	for i, branch := range branches {
+		if branch.Name == current {
+			menu.Current(items[i])
+		}
+	}
+
+	stdscr.Refresh()
+	win.Refresh()
 
-	items := make([]*gc.MenuItem, len(commits))
-	for i, commit := range commits {
-		label := " " + commit.Oid.String()[:16]
+	for {
+		gc.Update()
+		ch := win.GetChar()
+		if ch == 27 {
+			return nil
+		}
+
+		switch ch {
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			return branches[menu.Current(nil).Index()]
+		case gc.KEY_DOWN:
+			menu.Driver(gc.REQ_DOWN)
+		case gc.KEY_UP:
+			menu.Driver(gc.REQ_UP)
+		case gc.KEY_PAGEDOWN:
+			if menu.Driver(gc.REQ_PAGE_DOWN) != nil {
+				menu.Driver(gc.REQ_LAST)
+			}
+		case gc.KEY_PAGEUP:
+			if menu.Driver(gc.REQ_PAGE_UP) != nil {
+				menu.Driver(gc.REQ_FIRST)
+			}
+		}
+		win.Refresh()
+	}
+}
+
+// Lets the user pick one of the annotated tags
+func selectTag(stdscr *gc.Window, tags []*TagRef) *TagRef {
+	stdscr.Clear()
+	my, mx := stdscr.MaxYX()
+	title := "Tags"
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(my-2, 1, "'enter' to edit the tag, 'esc' to go back")
+	stdscr.Keypad(true)
+
+	rows := my - 7
+	if rows < 3 {
+		rows = 3
+	}
+	win, err := gc.NewWindow(rows+2, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	win.Keypad(true)
+	win.ColorOn(2)
+	win.Box(0, 0)
+	win.ColorOff(2)
+	dwin := win.Derived(rows, mx-2, 1, 1)
+
+	nameLength := 0
+	for _, tag := range tags {
+		if name := strings.TrimPrefix(tag.Name, "refs/tags/"); len(name) > nameLength {
+			nameLength = len(name)
+		}
+	}
+	messageLength := mx - nameLength - 40
+
+	items := make([]*gc.MenuItem, len(tags))
+	for i, tag := range tags {
+		subject := strings.Split(tagMessage(tag.Tag), "\n")[0]
+		if messageLength > 2 && len(subject) > messageLength {
+			subject = subject[:messageLength-2] + ".."
+		}
+		desc := fmt.Sprintf("%s %s - %s", tag.Tag.TargetId.String()[:12], tag.Tag.Tagger.When.Format(planDateLayout)[:16], subject)
+		items[i], _ = gc.NewItem(" "+strings.TrimPrefix(tag.Name, "refs/tags/"), desc)
+		defer items[i].Free()
+	}
+
+	menu, err := gc.NewMenu(items)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	menu.SetPad('-')
+	menu.SetSpacing(3, 1, 1)
+	menu.Format(rows, 1)
+	menu.SubWindow(dwin)
+	menu.Post()
+	defer menu.UnPost()
+	defer menu.Free()
+
+	stdscr.Refresh()
+	win.Refresh()
+
+	for {
+		gc.Update()
+		ch := win.GetChar()
+		if ch == 27 {
+			return nil
+		}
+
+		switch ch {
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			return tags[menu.Current(nil).Index()]
+		case gc.KEY_DOWN:
+			menu.Driver(gc.REQ_DOWN)
+		case gc.KEY_UP:
+			menu.Driver(gc.REQ_UP)
+		case gc.KEY_PAGEDOWN:
+			if menu.Driver(gc.REQ_PAGE_DOWN) != nil {
+				menu.Driver(gc.REQ_LAST)
+			}
+		case gc.KEY_PAGEUP:
+			if menu.Driver(gc.REQ_PAGE_UP) != nil {
+				menu.Driver(gc.REQ_FIRST)
+			}
+		}
+		win.Refresh()
+	}
+}
+
+// The editable fields of an annotated tag, in form order
+var tagFields = []struct {
+	label string
+	get   func(*gogit.Tag) string
+	set   func(*gogit.Tag, string) error
+}{
+	{"Tagger Name     :",
+		func(t *gogit.Tag) string { return t.Tagger.Name },
+		func(t *gogit.Tag, value string) error {
+			if err := checkName(value); err != nil {
+				return err
+			}
+			t.Tagger.Name = value
+			return nil
+		}},
+	{"Tagger Email    :",
+		func(t *gogit.Tag) string { return t.Tagger.Email },
+		func(t *gogit.Tag, value string) error {
+			if err := checkEmail(value); err != nil {
+				return err
+			}
+			t.Tagger.Email = value
+			return nil
+		}},
+	{"Tagger Date     :",
+		func(t *gogit.Tag) string { return t.Tagger.When.Format(localDateLayout) },
+		func(t *gogit.Tag, value string) (err error) {
+			t.Tagger.When, err = setDate(t.Tagger.When, value)
+			return err
+		}},
+	{"Tagger Offset   :",
+		func(t *gogit.Tag) string { return t.Tagger.When.Format(offsetLayout) },
+		func(t *gogit.Tag, value string) (err error) {
+			t.Tagger.When, err = setOffset(t.Tagger.When, value)
+			return err
+		}},
+}
+
+// Edits the tagger and message of an annotated tag. Returns the edited copy
+// of the tag, or nil if the user cancelled.
+func editTag(stdscr *gc.Window, tag *TagRef) *gogit.Tag {
+	stdscr.Clear()
+	_, mx := stdscr.MaxYX()
+	title := fmt.Sprintf("Edit Tag %s", strings.TrimPrefix(tag.Name, "refs/tags/"))
+	height := len(tagFields) + 4
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(height+6, 1, "'enter' to save, 'F2' to edit message, 'esc' to exit")
+	stdscr.Keypad(true)
+
+	win, err := gc.NewWindow(height+2, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dwin := win.Derived(height, mx-2, 1, 1)
+	win.Keypad(true)
+	win.ColorOn(1)
+	win.Box(0, 0)
+	win.ColorOff(1)
+
+	initial := make([]string, len(tagFields))
+	fields := make([]*gc.Field, len(tagFields))
+	for i, field := range tagFields {
+		fields[i], _ = gc.NewField(1, 32, int32(i), 19, 0, 0)
+		defer fields[i].Free()
+		fields[i].SetForeground(gc.ColorPair(3))
+		fields[i].SetBackground(gc.ColorPair(3) | gc.A_UNDERLINE | gc.A_BOLD)
+		fields[i].SetOptionsOff(gc.FO_AUTOSKIP | gc.FO_STATIC)
+		fields[i].SetMax(fieldMaxLength)
+		fields[i].SetBuffer(field.get(tag.Tag))
+		initial[i] = strings.TrimSpace(fields[i].Buffer())
+	}
+	message := tagMessage(tag.Tag)
+
+	form, _ := gc.NewForm(fields)
+	form.SetWindow(win)
+	form.SetSub(dwin)
+	form.Post()
+	defer form.UnPost()
+	defer form.Free()
+
+	for i, field := range tagFields {
+		dwin.MovePrint(i, 1, field.label)
+	}
 
-		// Get first line and trim description characters
-		trimMessage := strings.Split(commit.CommitMessage, "\n")[0]
+	messageLength := mx - 4
+	printMessage := func() {
+		lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+		trimMessage := fmt.Sprintf("Message: %s", lines[0])
+		if len(lines) > 1 {
+			trimMessage += fmt.Sprintf(" (+%d lines)", len(lines)-1)
+		}
 		if len(trimMessage) > messageLength {
 			trimMessage = trimMessage[:messageLength-2] + ".."
 		}
-		desc := commit.Committer.When.String()[5:19] + " - " + trimMessage
+		dwin.Move(len(tagFields)+1, 1)
+		dwin.ClearToEOL()
+		dwin.MovePrint(len(tagFields)+1, 1, trimMessage)
+	}
+	printMessage()
+
+	// Applies the changed fields to a copy of the tag like check in
+	// editCommits. Returns nil if any field is invalid.
+	noteLength := mx - 2 - 53 - 1
+	check := func() *gogit.Tag {
+		edited := copyTag(tag.Tag)
+		valid := true
+		for i, field := range tagFields {
+			value := strings.TrimSpace(fields[i].Buffer())
+			var err error
+			if value != strings.TrimSpace(initial[i]) {
+				err = field.set(edited, value)
+			}
+
+			fields[i].SetBackground(gc.ColorPair(3) | gc.A_UNDERLINE | gc.A_BOLD)
+			dwin.Move(i, 53)
+			dwin.ClearToEOL()
+			if err != nil {
+				valid = false
+				note := err.Error()
+				if len(note) > noteLength {
+					note = note[:noteLength-2] + ".."
+				}
+				fields[i].SetBackground(gc.ColorPair(3) | gc.A_REVERSE | gc.A_BOLD)
+				dwin.ColorOn(3)
+				dwin.MovePrint(i, 53, note)
+				dwin.ColorOff(3)
+			} else if normalized := field.get(edited); normalized != value {
+				fields[i].SetBuffer(normalized)
+			}
+		}
+
+		dwin.Move(len(tagFields)+3, 1)
+		dwin.ClearToEOL()
+		if !valid {
+			return nil
+		}
+		edited.Message = message
+		return edited
+	}
+	check()
+
+	stdscr.Refresh()
+	win.Refresh()
 
-		items[i], _ = gc.NewItem(label, desc)
+	form.Driver(gc.REQ_FIRST_FIELD)
+
+	ch := win.GetChar()
+	for ch != 27 {
+		switch ch {
+		case gc.KEY_ENTER, gc.KEY_RETURN:
+			form.Driver(gc.REQ_VALIDATION)
+
+			edited := check()
+			if edited == nil {
+				dwin.ColorOn(3)
+				dwin.MovePrint(len(tagFields)+3, 1, "Fix the highlighted fields to save.")
+				dwin.ColorOff(3)
+				break
+			}
+			return edited
+		case gc.KEY_F2:
+			if edited, ok := editMessage(stdscr, message); ok && edited != message {
+				message = edited
+				printMessage()
+			}
+			stdscr.Touch()
+			stdscr.Refresh()
+			win.Touch()
+			win.Refresh()
+			form.Driver(gc.REQ_FIRST_FIELD)
+		case gc.KEY_LEFT:
+			form.Driver(gc.REQ_PREV_CHAR)
+		case gc.KEY_RIGHT:
+			form.Driver(gc.REQ_NEXT_CHAR)
+		case gc.KEY_DOWN, gc.KEY_TAB:
+			form.Driver(gc.REQ_VALIDATION)
+			check()
+			form.Driver(gc.REQ_NEXT_FIELD)
+		case gc.KEY_UP:
+			form.Driver(gc.REQ_VALIDATION)
+			check()
+			form.Driver(gc.REQ_PREV_FIELD)
+		case gc.KEY_BACKSPACE, 127:
+			form.Driver(gc.REQ_DEL_PREV)
+		case gc.KEY_DC:
+			form.Driver(gc.REQ_DEL_CHAR)
+		case gc.KEY_HOME:
+			form.Driver(gc.REQ_BEG_FIELD)
+		case gc.KEY_END:
+			form.Driver(gc.REQ_END_FIELD)
+		default:
+			form.Driver(ch)
+		}
+		win.Refresh()
+		ch = stdscr.GetChar()
+	}
+
+	return nil
+}
+
+func selectBackup(stdscr *gc.Window, backups []*Backup) *Backup {
+	stdscr.Clear()
+	_, mx := stdscr.MaxYX()
+	title := "Backups"
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(16, 1, "'enter' to restore, 'esc' to exit")
+	stdscr.Keypad(true)
+
+	win, err := gc.NewWindow(12, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	win.Keypad(true)
+	win.ColorOn(2)
+	win.Box(0, 0)
+	win.ColorOff(2)
+	dwin := win.Derived(10, mx-2, 1, 1)
+
+	items := make([]*gc.MenuItem, len(backups))
+	for i, backup := range backups {
+		ref := backup.Refs[0]
+		desc := fmt.Sprintf("%s %s", ref.Oid.String()[:12], ref.Name)
+		if len(backup.Refs) > 1 {
+			desc += fmt.Sprintf(" (+%d refs)", len(backup.Refs)-1)
+		}
+
+		items[i], _ = gc.NewItem(" "+backup.Id, desc)
 		defer items[i].Free()
 	}
 
@@ -70,8 +1119,7 @@ func selectCommit(stdscr *gc.Window, commits []*gogit.Commit) *gogit.Commit {
 
 		switch gc.KeyString(ch) {
 		case "enter":
-			index := menu.Current(nil).Index()
-			return commits[index]
+			return backups[menu.Current(nil).Index()]
 		case "down":
 			menu.Driver(gc.REQ_DOWN)
 		case "up":
@@ -80,12 +1128,19 @@ func selectCommit(stdscr *gc.Window, commits []*gogit.Commit) *gogit.Commit {
 	}
 }
 
-func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
+type identityReplacement struct {
+	from, to          identity
+	author, committer bool
+}
+
+// Asks which identity to replace on commit and its descendants, prefilled
+// with the author of commit.
+func editIdentityReplacement(stdscr *gc.Window, commit *gogit.Commit) *identityReplacement {
 	stdscr.Clear()
 	_, mx := stdscr.MaxYX()
-	title := fmt.Sprintf("Edit Commit %s", commit.Oid.String())
+	title := fmt.Sprintf("Replace Identity since %s", commit.Oid.String()[:16])
 	stdscr.MovePrint(1, mx/2-len(title)/2, title)
-	stdscr.MovePrint(16, 1, "'enter' to save, 'esc' to exit")
+	stdscr.MovePrint(16, 1, "'enter' to replace, 'esc' to exit")
 	stdscr.Keypad(true)
 
 	win, err := gc.NewWindow(12, mx, 3, 0)
@@ -98,21 +1153,26 @@ func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
 	win.Box(0, 0)
 	win.ColorOff(1)
 
-	fields := make([]*gc.Field, 6)
-	for i := 0; i < 6; i++ {
-		fields[i], _ = gc.NewField(1, 30, int32(i), 19, 0, 0)
+	fields := make([]*gc.Field, 4)
+	for i := 0; i < 4; i++ {
+		width := int32(40)
+		if i >= 2 {
+			width = 1
+		}
+		fields[i], _ = gc.NewField(1, width, int32(i), 19, 0, 0)
 		defer fields[i].Free()
 		fields[i].SetForeground(gc.ColorPair(3))
 		fields[i].SetBackground(gc.ColorPair(3) | gc.A_UNDERLINE | gc.A_BOLD)
 		fields[i].SetOptionsOff(gc.FO_AUTOSKIP)
+		if i < 2 {
+			fields[i].SetOptionsOff(gc.FO_STATIC)
+			fields[i].SetMax(fieldMaxLength)
+		}
 	}
 
-	fields[0].SetBuffer(commit.Author.Name)
-	fields[1].SetBuffer(commit.Author.Email)
-	fields[2].SetBuffer(commit.Author.When.String())
-	fields[3].SetBuffer(commit.Committer.Name)
-	fields[4].SetBuffer(commit.Committer.Email)
-	fields[5].SetBuffer(commit.Committer.When.String())
+	fields[0].SetBuffer(identity{commit.Author.Name, commit.Author.Email}.String())
+	fields[2].SetBuffer("y")
+	fields[3].SetBuffer("y")
 
 	form, _ := gc.NewForm(fields)
 	form.SetWindow(win)
@@ -121,19 +1181,10 @@ func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
 	defer form.UnPost()
 	defer form.Free()
 
-	dwin.MovePrint(0, 1, "Author Name    :")
-	dwin.MovePrint(1, 1, "Author Email   :")
-	dwin.MovePrint(2, 1, "Author Date    :")
-	dwin.MovePrint(3, 1, "Committer Name :")
-	dwin.MovePrint(4, 1, "Committer Email:")
-	dwin.MovePrint(5, 1, "Committer Date :")
-
-	messageLength := mx - 4
-	trimMessage := fmt.Sprintf("Message: %s", strings.Split(commit.CommitMessage, "\n")[0])
-	if len(trimMessage) > messageLength {
-		trimMessage = trimMessage[:messageLength-2] + ".."
-	}
-	dwin.MovePrint(7, 1, trimMessage)
+	dwin.MovePrint(0, 1, "From           :")
+	dwin.MovePrint(1, 1, "To             :")
+	dwin.MovePrint(2, 1, "Author (y/n)   :")
+	dwin.MovePrint(3, 1, "Committer (y/n):")
 
 	stdscr.Refresh()
 	win.Refresh()
@@ -146,18 +1197,26 @@ func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
 		case gc.KEY_ENTER, gc.KEY_RETURN:
 			form.Driver(gc.REQ_VALIDATION)
 
-			const sample = "2006-01-02 15:04:05 -0700 MST"
-			authorTime, _ := time.Parse(sample, strings.TrimSpace(fields[2].Buffer()))
-			committerTime, _ := time.Parse(sample, strings.TrimSpace(fields[5].Buffer()))
+			from, err := parseIdentity(fields[0].Buffer())
+			var to identity
+			if err == nil {
+				to, err = parseIdentity(fields[1].Buffer())
+			}
+			if err == nil {
+				return &identityReplacement{
+					from:      from,
+					to:        to,
+					author:    strings.TrimSpace(fields[2].Buffer()) == "y",
+					committer: strings.TrimSpace(fields[3].Buffer()) == "y",
+				}
+			}
 
-			commit.Author.Name = strings.TrimSpace(fields[0].Buffer())
-			commit.Author.Email = strings.TrimSpace(fields[1].Buffer())
-			commit.Author.When = authorTime
-			commit.Committer.Name = strings.TrimSpace(fields[3].Buffer())
-			commit.Committer.Email = strings.TrimSpace(fields[4].Buffer())
-			commit.Committer.When = committerTime
-
-			return commit
+			dwin.Move(5, 1)
+			dwin.ClearToEOL()
+			dwin.ColorOn(3)
+			dwin.MovePrint(5, 1, err.Error())
+			dwin.ColorOff(3)
+			form.Driver(gc.REQ_FIRST_FIELD)
 		case gc.KEY_LEFT:
 			form.Driver(gc.REQ_PREV_CHAR)
 		case gc.KEY_RIGHT:
@@ -170,6 +1229,10 @@ func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
 			form.Driver(gc.REQ_DEL_PREV)
 		case gc.KEY_DC:
 			form.Driver(gc.REQ_DEL_CHAR)
+		case gc.KEY_HOME:
+			form.Driver(gc.REQ_BEG_FIELD)
+		case gc.KEY_END:
+			form.Driver(gc.REQ_END_FIELD)
 		default:
 			form.Driver(ch)
 		}
@@ -180,16 +1243,148 @@ func editCommit(stdscr *gc.Window, commit *gogit.Commit) *gogit.Commit {
 	return nil
 }
 
+// Shows lines in a scrollable window until 'esc' or 'q' is pressed
+func showLines(stdscr *gc.Window, title string, lines []string) {
+	pageLines(stdscr, title, "'up'/'down' to scroll, 'esc' to exit", lines, 'q')
+}
+
+// Shows lines in a scrollable window until 'esc' or one of keys is pressed,
+// and returns that key
+func pageLines(stdscr *gc.Window, title, help string, lines []string, keys ...gc.Key) gc.Key {
+	stdscr.Clear()
+	my, mx := stdscr.MaxYX()
+	stdscr.MovePrint(1, mx/2-len(title)/2, title)
+	stdscr.MovePrint(my-2, 1, help)
+	stdscr.Keypad(true)
+	stdscr.Refresh()
+
+	h := my - 6
+	win, err := gc.NewWindow(h+2, mx, 3, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer win.Delete()
+	win.Keypad(true)
+	dwin := win.Derived(h, mx-2, 1, 1)
+
+	top := 0
+	for {
+		win.Erase()
+		win.ColorOn(2)
+		win.Box(0, 0)
+		win.ColorOff(2)
+		for y := 0; y < h && top+y < len(lines); y++ {
+			line := lines[top+y]
+			if len(line) > mx-3 {
+				line = line[:mx-5] + ".."
+			}
+			dwin.MovePrint(y, 0, line)
+		}
+		win.Refresh()
+
+		ch := win.GetChar()
+		if ch == 27 {
+			return ch
+		}
+		for _, key := range keys {
+			if ch == key {
+				return ch
+			}
+		}
+		switch ch {
+		case gc.KEY_DOWN:
+			if top+h < len(lines) {
+				top++
+			}
+		case gc.KEY_UP:
+			if top > 0 {
+				top--
+			}
+		case gc.KEY_PAGEDOWN:
+			top += h
+			if top+h > len(lines) {
+				top = len(lines) - h
+			}
+			if top < 0 {
+				top = 0
+			}
+		case gc.KEY_PAGEUP:
+			top -= h
+			if top < 0 {
+				top = 0
+			}
+		}
+	}
+}
+
+// Shows the refs a rewrite will move and its plan, and asks to go ahead
+func confirmRewrite(stdscr *gc.Window, result *RewriteResult) bool {
+	lines := []string{fmt.Sprintf("%d refs will be moved:", len(result.Refs))}
+	if len(result.Refs) == 1 {
+		lines[0] = "1 ref will be moved:"
+	}
+	for _, ref := range result.Refs {
+		lines = append(lines, fmt.Sprintf("  %s: %s -> %s", ref.Name, ref.Old.String()[:12], ref.New.String()[:12]))
+	}
+	if len(result.Published) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, formatPublished(result)...)
+	}
+	if len(result.UnsignedTags) > 0 {
+		lines = append(lines, "", formatUnsignedTags(result, "will be dropped"))
+	}
+	lines = append(lines, "")
+	lines = append(lines, formatPlanTable(result)...)
+
+	key := pageLines(stdscr, "Confirm Rewrite", "'y' to rewrite, 'n' or 'esc' to cancel, 'up'/'down' to scroll", lines, 'y', 'n')
+	return key == 'y'
+}
+
+// Shows the full message, parents, tree and changed files of commit until
+// 'd' or 'esc' is pressed
+func showCommitDetail(stdscr *gc.Window, commitLog *CommitLog, commit *gogit.Commit) {
+	title := fmt.Sprintf("Commit %s", commit.Oid.String()[:16])
+	pageLines(stdscr, title, "'up'/'down' to scroll, 'd' or 'esc' to go back", commitLog.repo.commitDetail(commit), 'd', 'q')
+}
+
+// Asks whether to push the rewritten branch ref name to upstream
+func confirmPush(stdscr *gc.Window, name string, upstream *Upstream, lease *gogit.Oid) bool {
+	lines := []string{
+		fmt.Sprintf("Push %s to %s?", shortRefName(name), upstream),
+		"",
+		fmt.Sprintf("The push is forced only while the remote branch is still at %s,", lease.String()[:12]),
+		fmt.Sprintf("where %s says it is:", shortRefName(upstream.Tracking)),
+		"",
+		"  git " + strings.Join(pushArgs(name, upstream, lease), " "),
+	}
+	key := pageLines(stdscr, "Push", "'y' to push, 'n' or 'esc' to skip", lines, 'y', 'n')
+	return key == 'y'
+}
+
+func showPlan(stdscr *gc.Window, result *RewriteResult) {
+	showLines(stdscr, "Dry Run - No Refs Changed", formatPlan(result))
+}
+
 func showResult(stdscr *gc.Window, result string) {
+	title := "No Changes. Exiting."
+	if result != "" {
+		title = fmt.Sprintf("Changed: %s.", result)
+	}
+	showMessage(stdscr, title)
+}
+
+func showMessage(stdscr *gc.Window, title string) {
 	_, mx := stdscr.MaxYX()
 	h, w := 10, 40
+	if len(title)+4 > mx {
+		title = title[:mx-6] + ".."
+	}
+	if len(title)+4 > w {
+		w = len(title) + 4
+	}
 	y, x := 4, (mx-w)/2
 
-	title := "No Changes. Exiting."
 	exit := "Press any key to quit."
-	if result != "" {
-		title = fmt.Sprintf("Changed: %s.", result)
-	}
 	window, _ := gc.NewWindow(h, w, y, x)
 	window.Box(0, 0)
 	window.MovePrint(1, (w/2)-(len(title)/2), title)
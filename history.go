@@ -0,0 +1,397 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lists the commits reachable from include but not from exclude, newest
+// first.
+func (r *Repo) revList(include, exclude []*gogit.Oid) ([]*gogit.Oid, error) {
+	excluded := make(map[gogit.SHA1]bool)
+	if len(exclude) > 0 {
+		hidden, err := r.revList(exclude, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, oid := range hidden {
+			excluded[oid.Bytes] = true
+		}
+	}
+
+	var list []*gogit.Oid
+	seen := make(map[gogit.SHA1]bool)
+	queue := append([]*gogit.Oid(nil), include...)
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if seen[oid.Bytes] || excluded[oid.Bytes] {
+			continue
+		}
+		seen[oid.Bytes] = true
+		list = append(list, oid)
+
+		data, err := r.readCommitData(oid)
+		if err != nil {
+			return nil, err
+		}
+		parents, err := parseCommitParents(data)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, parents...)
+	}
+	return list, nil
+}
+
+// Lists the commits of a range such as HEAD~3..HEAD or master..feature. A
+// single revision selects its whole history.
+func (r *Repo) RangeCommits(spec string) ([]*gogit.Oid, error) {
+	from, to := "", spec
+	if i := strings.Index(spec, ".."); i >= 0 {
+		from, to = spec[:i], spec[i+2:]
+		if from == "" {
+			return nil, fmt.Errorf("Invalid range %s", spec)
+		}
+	}
+
+	tip, err := r.ResolveCommit(to)
+	if err != nil {
+		return nil, err
+	}
+	var exclude []*gogit.Oid
+	if from != "" {
+		base, err := r.ResolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, base.Oid)
+	}
+	return r.revList([]*gogit.Oid{tip.Oid}, exclude)
+}
+
+// Maps every commit reachable from tip to its parents. With firstParent
+// only first parents are followed.
+func (r *Repo) parentGraph(tip *gogit.Oid, firstParent bool) (map[gogit.SHA1][]*gogit.Oid, error) {
+	graph := make(map[gogit.SHA1][]*gogit.Oid)
+	queue := []*gogit.Oid{tip}
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if _, seen := graph[oid.Bytes]; seen {
+			continue
+		}
+
+		data, err := r.readCommitData(oid)
+		if err != nil {
+			return nil, err
+		}
+		parents, err := parseCommitParents(data)
+		if err != nil {
+			return nil, err
+		}
+		if firstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		graph[oid.Bytes] = parents
+		queue = append(queue, parents...)
+	}
+	return graph, nil
+}
+
+// Orders the commits of graph reachable from tip like git log --topo-order:
+// every commit comes before its parents, and the commits merged by the
+// second parent of a merge come right after it.
+func topoOrder(tip *gogit.Oid, graph map[gogit.SHA1][]*gogit.Oid) []*gogit.Oid {
+	children := make(map[gogit.SHA1]int)
+	for _, parents := range graph {
+		for _, parent := range parents {
+			children[parent.Bytes]++
+		}
+	}
+
+	// A commit is ready once all of its children are listed. The last
+	// parent that gets ready is listed first.
+	var order []*gogit.Oid
+	stack := []*gogit.Oid{tip}
+	for len(stack) > 0 {
+		oid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, oid)
+		for _, parent := range graph[oid.Bytes] {
+			children[parent.Bytes]--
+			if children[parent.Bytes] == 0 {
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return order
+}
+
+// Walks the history of a tip in the order of topoOrder, reading commits only
+// as they are listed, like git log without a commit-graph file.
+//
+// Commits are read newest first by committer date. A commit is listed once
+// all of its children are listed and every commit whose parents are not
+// read yet is older than it, as none of those can lead to another child.
+// Only a commit dated before its parent can make its parent come first.
+type topoWalker struct {
+	repo        *Repo
+	firstParent bool
+
+	// Every commit read, the read commits that are not listed yet and the
+	// number of read children of a commit that are not listed yet
+	seen     map[gogit.SHA1]bool
+	commits  map[gogit.SHA1]*gogit.Commit
+	children map[gogit.SHA1]int
+
+	// Read commits whose parents have not been read, newest first
+	unread commitsByDate
+
+	// Commits whose children are all listed, the last is listed next
+	ready []*gogit.Commit
+}
+
+func (r *Repo) newTopoWalker(tip *gogit.Oid, firstParent bool) (*topoWalker, error) {
+	w := &topoWalker{
+		repo:        r,
+		firstParent: firstParent,
+		seen:        make(map[gogit.SHA1]bool),
+		commits:     make(map[gogit.SHA1]*gogit.Commit),
+		children:    make(map[gogit.SHA1]int),
+	}
+	commit, err := w.read(tip)
+	if err != nil {
+		return nil, err
+	}
+	w.ready = []*gogit.Commit{commit}
+	return w, nil
+}
+
+// Returns the parents of commit the walk follows
+func (w *topoWalker) parents(commit *gogit.Commit) []*gogit.Oid {
+	var parents []*gogit.Oid
+	for i := 0; i < commit.ParentCount(); i++ {
+		if w.firstParent && i > 0 {
+			break
+		}
+		parents = append(parents, commit.ParentId(i))
+	}
+	return parents
+}
+
+// Reads the commit oid and counts it as a child of its parents
+func (w *topoWalker) read(oid *gogit.Oid) (*gogit.Commit, error) {
+	commit, err := w.repo.repository.LookupCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	w.seen[oid.Bytes] = true
+	w.commits[oid.Bytes] = commit
+	for _, parent := range w.parents(commit) {
+		w.children[parent.Bytes]++
+	}
+	heap.Push(&w.unread, commit)
+	return commit, nil
+}
+
+// Reads the parents of the newest commit whose parents are not read yet
+func (w *topoWalker) readNewest() error {
+	commit := heap.Pop(&w.unread).(*gogit.Commit)
+	for _, parent := range w.parents(commit) {
+		if w.seen[parent.Bytes] {
+			continue
+		}
+		if _, err := w.read(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drops commits from the top of ready that were listed already, or have
+// children that are not
+func (w *topoWalker) prune() {
+	for len(w.ready) > 0 {
+		top := w.ready[len(w.ready)-1].Oid.Bytes
+		if _, unlisted := w.commits[top]; unlisted && w.children[top] == 0 {
+			return
+		}
+		w.ready = w.ready[:len(w.ready)-1]
+	}
+}
+
+// Reports whether all commits were listed
+func (w *topoWalker) Done() bool {
+	w.prune()
+	return len(w.ready) == 0
+}
+
+// Returns the next commit of the history, or nil when all were listed
+func (w *topoWalker) Next() (*gogit.Commit, error) {
+	for {
+		w.prune()
+		if len(w.ready) == 0 {
+			return nil, nil
+		}
+		commit := w.ready[len(w.ready)-1]
+		for len(w.unread) > 0 && !w.unread[0].Committer.When.Before(commit.Committer.When) {
+			if err := w.readNewest(); err != nil {
+				return nil, err
+			}
+		}
+		if w.children[commit.Oid.Bytes] > 0 {
+			// A child turned up, list it first
+			continue
+		}
+
+		w.ready = w.ready[:len(w.ready)-1]
+		delete(w.commits, commit.Oid.Bytes)
+		for _, parent := range w.parents(commit) {
+			w.children[parent.Bytes]--
+			if w.children[parent.Bytes] == 0 {
+				if next, ok := w.commits[parent.Bytes]; ok {
+					w.ready = append(w.ready, next)
+				}
+			}
+		}
+		return commit, nil
+	}
+}
+
+// Commits ordered newest first by committer date, for container/heap
+type commitsByDate []*gogit.Commit
+
+func (c commitsByDate) Len() int           { return len(c) }
+func (c commitsByDate) Less(i, j int) bool { return c[i].Committer.When.After(c[j].Committer.When) }
+func (c commitsByDate) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+func (c *commitsByDate) Push(x interface{}) { *c = append(*c, x.(*gogit.Commit)) }
+
+func (c *commitsByDate) Pop() interface{} {
+	old := *c
+	commit := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return commit
+}
+
+// Lists commit and every commit on the target branch that descends from it,
+// newest first. Commits merged in from branches that do not contain commit
+// are left out.
+func (r *Repo) CommitsSince(commit *gogit.Commit) ([]*gogit.Oid, error) {
+	_, head, err := r.resolveTarget()
+	if err != nil {
+		return nil, err
+	}
+	graph, err := r.parentGraph(head, false)
+	if err != nil {
+		return nil, err
+	}
+	order := topoOrder(head, graph)
+
+	descends := map[gogit.SHA1]bool{commit.Oid.Bytes: true}
+	for i := len(order) - 1; i >= 0; i-- {
+		for _, parent := range graph[order[i].Bytes] {
+			if descends[parent.Bytes] {
+				descends[order[i].Bytes] = true
+			}
+		}
+	}
+
+	var list []*gogit.Oid
+	for _, oid := range order {
+		if descends[oid.Bytes] {
+			list = append(list, oid)
+		}
+	}
+	return list, nil
+}
+
+// An edited commit that remote-tracking refs already contain
+type PublishedCommit struct {
+	Oid *gogit.Oid
+
+	// Short names of the remote-tracking refs, e.g. "origin/main"
+	Remotes []string
+}
+
+// Lists the commits that are reachable from a remote-tracking ref in
+// refs/remotes/, in the order of commits. The history of all remote-tracking
+// refs is walked once, completely, so that every ref above a commit is found.
+func (r *Repo) publishedCommits(commits []*gogit.Commit) ([]*PublishedCommit, error) {
+	remotes, err := r.listRefs("refs/remotes/")
+	if err != nil {
+		return nil, err
+	}
+
+	tips := make(map[gogit.SHA1][]string)
+	var queue []*gogit.Oid
+	for _, ref := range remotes {
+		// origin/HEAD only repeats the default branch
+		if strings.HasSuffix(ref.Name, "/HEAD") {
+			continue
+		}
+		if objectType, err := r.repository.Type(ref.Oid); err != nil || objectType != gogit.ObjectCommit {
+			continue
+		}
+		tips[ref.Oid.Bytes] = append(tips[ref.Oid.Bytes], strings.TrimPrefix(ref.Name, "refs/remotes/"))
+		queue = append(queue, ref.Oid)
+	}
+
+	seen := make(map[gogit.SHA1]bool)
+	children := make(map[gogit.SHA1][]gogit.SHA1)
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if seen[oid.Bytes] {
+			continue
+		}
+		seen[oid.Bytes] = true
+
+		data, err := r.readCommitData(oid)
+		if err != nil {
+			return nil, err
+		}
+		parents, err := parseCommitParents(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			children[parent.Bytes] = append(children[parent.Bytes], oid.Bytes)
+		}
+		queue = append(queue, parents...)
+	}
+
+	var published []*PublishedCommit
+	for _, commit := range commits {
+		if seen[commit.Oid.Bytes] {
+			published = append(published, &PublishedCommit{Oid: commit.Oid, Remotes: remotesAbove(commit.Oid.Bytes, children, tips)})
+		}
+	}
+	return published, nil
+}
+
+// Returns the names of the tips found by following children up from the
+// commit id, sorted
+func remotesAbove(id gogit.SHA1, children map[gogit.SHA1][]gogit.SHA1, tips map[gogit.SHA1][]string) []string {
+	var names []string
+	seen := map[gogit.SHA1]bool{id: true}
+	stack := []gogit.SHA1{id}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		names = append(names, tips[id]...)
+		for _, child := range children[id] {
+			if !seen[child] {
+				seen[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
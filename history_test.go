@@ -0,0 +1,228 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishedCommits(t *testing.T) {
+	repo, _ := testRepo(t, `
+		for i in $(seq 0 20); do git commit -q --allow-empty -m $i; done
+		git update-ref refs/remotes/origin/near main~19
+		git update-ref refs/remotes/origin/far main~1
+		git symbolic-ref refs/remotes/origin/HEAD refs/remotes/origin/far
+		git checkout -q -b side main~20
+		git commit -q --allow-empty -m side
+		git update-ref refs/remotes/fork/side side
+		git checkout -q main`)
+
+	var commits []*gogit.Commit
+	for _, rev := range []string{"main~5", "main~20", "side"} {
+		commits = append(commits, mustResolve(t, repo, rev))
+	}
+	published, err := repo.publishedCommits(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range published {
+		got = append(got, p.Oid.String()[:7]+" "+strings.Join(p.Remotes, ","))
+	}
+	exp := []string{
+		commits[0].Oid.String()[:7] + " origin/far",
+		commits[1].Oid.String()[:7] + " fork/side,origin/far,origin/near",
+		commits[2].Oid.String()[:7] + " fork/side",
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("publishedCommits() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+
+	// Commits above every remote-tracking ref are not published
+	if published, err := repo.publishedCommits([]*gogit.Commit{mustResolve(t, repo, "main")}); err != nil || len(published) != 0 {
+		t.Errorf("publishedCommits() = %v %v for an unpublished commit", published, err)
+	}
+
+	// Without remote-tracking refs nothing is published
+	repo, _ = testRepo(t, "git commit -q --allow-empty -m one")
+	if published, err := repo.publishedCommits([]*gogit.Commit{mustResolve(t, repo, "main")}); err != nil || len(published) != 0 {
+		t.Errorf("publishedCommits() = %v %v without remotes", published, err)
+	}
+}
+
+func TestSavePublishedCommit(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git update-ref refs/remotes/origin/main main~1`)
+	main := runGit(t, dir, "git rev-parse main")
+
+	// Unpublished commits are rewritten without asking
+	commit := mustResolve(t, repo, "main")
+	commit.Author.Name = "Edited"
+	if _, err := repo.SaveCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	main = runGit(t, dir, "git rev-parse main")
+
+	commit = mustResolve(t, repo, "main~1")
+	commit.Author.Name = "Edited"
+	if _, err := repo.SaveCommit(commit); err == nil || !strings.Contains(err.Error(), "is already on origin/main, pass --allow-published") {
+		t.Errorf("SaveCommit() = %v, want a published error", err)
+	}
+	if got := runGit(t, dir, "git rev-parse main"); got != main {
+		t.Errorf("main moved to %s", got)
+	}
+
+	repo.AllowPublished = true
+	if _, err := repo.SaveCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, dir, "git log -2 --format=%an main"); got != "Edited\nEdited" {
+		t.Errorf("authors after the rewrite:\n%s", got)
+	}
+}
+
+// Commits one second apart, with branches merged in both directions
+const mergeHistory = `
+	n=0
+	tick() { n=$((n+1)); export GIT_COMMITTER_DATE="$((1500000000+n)) +0000" GIT_AUTHOR_DATE="$((1500000000+n)) +0000"; }
+	commit() { tick; git commit -q --allow-empty -m "$1"; }
+	commit base
+	git checkout -q -b side
+	commit side1
+	git checkout -q main
+	commit main1
+	git checkout -q -b feature
+	commit feature1
+	git checkout -q side
+	commit side2
+	git checkout -q main
+	tick; git merge -q --no-ff -m "merge side" side
+	commit main2
+	git checkout -q feature
+	tick; git merge -q --no-ff -m "merge main" main
+	commit feature2
+	git checkout -q main
+	tick; git merge -q --no-ff -m "merge feature" feature
+	commit top`
+
+func TestCommitLogOrder(t *testing.T) {
+	// With all commits made in the same second the whole history is read
+	// before the first commit is listed
+	sameSecond := strings.Replace(mergeHistory, "n=$((n+1)); ", "", 1)
+	for i, firstParent := range []bool{false, true, false} {
+		history := mergeHistory
+		if i == 2 {
+			history = sameSecond
+		}
+		repo, dir := testRepo(t, history)
+		repo.FirstParent = firstParent
+		args := "--topo-order"
+		if firstParent {
+			args += " --first-parent"
+		}
+
+		commitLog, err := repo.NewCommitLog(0, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for commitLog.LoadMore(1) == 1 {
+		}
+		if !commitLog.Done() {
+			t.Errorf("first parent %v: not done after loading everything", firstParent)
+		}
+		var subjects []string
+		for _, commit := range commitLog.Commits {
+			subjects = append(subjects, strings.TrimSpace(commit.CommitMessage))
+		}
+		if got, exp := strings.Join(subjects, "\n"), runGit(t, dir, "git log --format=%s "+args); got != exp {
+			t.Errorf("first parent %v: commits\n%s\nwant\n%s", firstParent, got, exp)
+		}
+
+		// The same order and graph as from the whole history at once
+		tip := mustResolve(t, repo, "main").Oid
+		graph, err := repo.parentGraph(tip, firstParent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var drawer graphDrawer
+		for i, oid := range topoOrder(tip, graph) {
+			commit := commitLog.Commits[i]
+			if row := drawer.row(oid, graph[oid.Bytes]); !commit.Oid.Equal(oid) || commitLog.Graph(commit) != row {
+				t.Errorf("first parent %v: row %d is %s %q, want %s %q", firstParent, i, commit.Oid, commitLog.Graph(commit), oid, row)
+			}
+		}
+	}
+}
+
+func TestCommitLogGraph(t *testing.T) {
+	repo, _ := testRepo(t, mergeHistory)
+	commitLog, err := repo.NewCommitLog(0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitLog.LoadMore(100)
+
+	var rows []string
+	for _, commit := range commitLog.Commits {
+		rows = append(rows, commitLog.Graph(commit)+" "+strings.TrimSpace(commit.CommitMessage))
+	}
+	exp := []string{
+		"* top",
+		"* \\ merge feature",
+		"| * feature2",
+		"| * merge main",
+		"* | main2",
+		"* | \\ merge side",
+		"| | * side2",
+		"| | * side1",
+		"| * | feature1",
+		"* / | main1",
+		"* / base",
+	}
+	if got := strings.Join(rows, "\n"); got != strings.Join(exp, "\n") {
+		t.Errorf("graph\n%s\nwant\n%s", got, strings.Join(exp, "\n"))
+	}
+	if commitLog.GraphWidth != len("| * | feature1")-len(" feature1") {
+		t.Errorf("GraphWidth = %d", commitLog.GraphWidth)
+	}
+}
+
+// Only the history needed for the loaded commits is read
+func TestCommitLogLoadsLazily(t *testing.T) {
+	repo, _ := testRepo(t, `
+		for i in $(seq 1 50); do
+			GIT_COMMITTER_DATE="$((1500000000+i)) +0000" git commit -q --allow-empty -m $i
+		done`)
+
+	commitLog, err := repo.NewCommitLog(0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded := commitLog.LoadMore(10); loaded != 10 || commitLog.Done() {
+		t.Fatalf("LoadMore(10) = %d, done %v", loaded, commitLog.Done())
+	}
+	if read := len(commitLog.walker.seen); read > 12 {
+		t.Errorf("%d commits read for 10 listed", read)
+	}
+	if got := strings.TrimSpace(commitLog.Commits[9].CommitMessage); got != "41" {
+		t.Errorf("10th commit %s, want 41", got)
+	}
+
+	// --count stops the walk
+	commitLog, err = repo.NewCommitLog(5, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walker := commitLog.walker
+	if loaded := commitLog.LoadMore(100); loaded != 5 || !commitLog.Done() {
+		t.Errorf("LoadMore(100) = %d with a limit of 5, done %v", loaded, commitLog.Done())
+	}
+	if read := len(walker.seen); read > 7 {
+		t.Errorf("%d commits read for 5 listed", read)
+	}
+}
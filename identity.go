@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"fmt"
+	"log"
+	"strings"
+)
+
+// An identity as written in commit headers, "Name <email>". Either part may
+// be empty when used as a pattern, in which case it matches anything.
+type identity struct {
+	Name  string
+	Email string
+}
+
+func parseIdentity(s string) (identity, error) {
+	s = strings.TrimSpace(s)
+	start := strings.Index(s, "<")
+	if start < 0 {
+		if s == "" {
+			return identity{}, fmt.Errorf("Identity is empty")
+		}
+		return identity{Name: s}, nil
+	}
+	end := strings.Index(s, ">")
+	if end < start || strings.TrimSpace(s[end+1:]) != "" {
+		return identity{}, fmt.Errorf("Invalid identity %q, expected \"Name <email>\"", s)
+	}
+	return identity{
+		Name:  strings.TrimSpace(s[:start]),
+		Email: strings.TrimSpace(s[start+1 : end]),
+	}, nil
+}
+
+// Checks a name entered for a signature
+func checkName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("Name may not be empty")
+	}
+	if strings.ContainsAny(name, "<>\n") {
+		return fmt.Errorf("Name may not contain '<', '>' or newlines")
+	}
+	return nil
+}
+
+// Checks an email entered for a signature: user@host without whitespace
+func checkEmail(email string) error {
+	if strings.ContainsAny(email, "<>\n") {
+		return fmt.Errorf("Email may not contain '<', '>' or newlines")
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t") {
+		return fmt.Errorf("Email must look like user@host")
+	}
+	return nil
+}
+
+func (id identity) String() string {
+	return fmt.Sprintf("%s <%s>", id.Name, id.Email)
+}
+
+func (id identity) matches(sig *gogit.Signature) bool {
+	return (id.Name == "" || id.Name == sig.Name) &&
+		(id.Email == "" || strings.EqualFold(id.Email, sig.Email))
+}
+
+// Copies the non-empty parts of id into sig
+func (id identity) apply(sig *gogit.Signature) {
+	if id.Name != "" {
+		sig.Name = id.Name
+	}
+	if id.Email != "" {
+		sig.Email = id.Email
+	}
+}
+
+// Replaces the author and/or committer identity from with to on every
+// matching commit in commits, in a single rewrite. Returns a nil result when
+// no commit matched, and the number of edited commits.
+func (r *Repo) ReplaceIdentity(commits []*gogit.Oid, from, to identity, author, committer bool) (*RewriteResult, int, error) {
+	var edited []*gogit.Commit
+	for _, oid := range commits {
+		commit, err := r.repository.LookupCommit(oid)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Error reading commit %s: %s", oid, err)
+		}
+
+		authorBefore, committerBefore := *commit.Author, *commit.Committer
+		if author && from.matches(commit.Author) {
+			to.apply(commit.Author)
+		}
+		if committer && from.matches(commit.Committer) {
+			to.apply(commit.Committer)
+		}
+		if *commit.Author != authorBefore || *commit.Committer != committerBefore {
+			edited = append(edited, commit)
+		}
+	}
+
+	if len(edited) == 0 {
+		log.Printf("No commit matches %s, not saving.", from)
+		return nil, 0, nil
+	}
+
+	result, err := r.SaveCommits(edited)
+	return result, len(edited), err
+}
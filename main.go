@@ -2,11 +2,15 @@ package main
 
 import (
 	gc "github.com/rthornton128/goncurses"
+	"github.com/speedata/gogit"
 	"github.com/urfave/cli"
 
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -19,32 +23,82 @@ func main() {
 			Name:  "d, debug",
 			Usage: "Write debug log (glt.log)",
 		},
+		dryRunFlag,
+		allowPublishedFlag,
+		cli.IntFlag{
+			Name:  "c, count",
+			Usage: "List at most `N` commits (default: all, loaded while scrolling)",
+		},
+		cli.StringFlag{
+			Name:  "s, since",
+			Usage: "List only commits committed after `DATE`",
+		},
+		cli.BoolFlag{
+			Name:  "first-parent",
+			Usage: "Follow only the first parent of merge commits in the commit list",
+		},
+		cli.StringFlag{
+			Name:  "b, branch",
+			Usage: "Edit the history of `BRANCH` instead of the checked out branch",
+		},
+		cli.StringFlag{
+			Name:  "repo",
+			Usage: "Use the repository at or above `PATH` instead of the current directory",
+		},
 	}
+	app.Commands = []cli.Command{
+		setCommand,
+		replaceIdentityCommand,
+		backupsCommand,
+		undoCommand,
+		pushCommand,
+	}
+	app.Before = func(c *cli.Context) error {
+		if c.IsSet("debug") {
+			f, err := os.OpenFile("glt.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+			if err != nil {
+				log.Fatalf("error opening log file: %v", err)
+			}
+			log.SetOutput(f)
+		}
+		return nil
+	}
+	app.ArgsUsage = "[branch]"
 	app.Action = func(c *cli.Context) {
-		repo, err := OpenCurrentRepository()
+		repo, err := openRepository(c)
 		if err != nil {
 			log.Fatalf("error opening repository: %v", err)
 		}
 
-		dirty := repo.IsDirty()
+		repo.DryRun = c.Bool("dry-run")
+		repo.FirstParent = c.Bool("first-parent")
+
+		if c.NArg() > 0 {
+			if err := repo.UseRef(c.Args().First()); err != nil {
+				log.Fatalf("error selecting branch: %v", err)
+			}
+		}
+
+		// Rewriting another branch leaves the work tree alone
+		dirty := repo.Ref == "" && repo.IsDirty()
 		if dirty == true {
 			log.Fatal("git directory has uncommited changes, please stash and try agian.")
 		}
 
-		commits, err := repo.GetLog(10)
+		var since time.Time
+		if c.IsSet("since") {
+			if since, err = parseDate(c.String("since"), time.Now()); err != nil {
+				log.Fatalf("error parsing --since: %v", err)
+			}
+		}
+		commitLog, err := repo.NewCommitLog(c.Int("count"), since)
 		if err != nil {
 			log.Fatalf("error getting commit log: %v", err)
 		}
+		commitLog.LoadMore(100)
 
-		if c.IsSet("debug") {
-			// Initialize file logging just before curses
-			f, err := os.OpenFile("glt.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-			if err != nil {
-				log.Fatalf("error opening log file: %v", err)
-			}
-			defer f.Close()
-			log.SetOutput(f)
-		} else {
+		// Keep errors on stderr until curses takes over the terminal
+		if !c.IsSet("debug") {
 			log.SetFlags(0)
 			log.SetOutput(ioutil.Discard)
 		}
@@ -64,28 +118,234 @@ func main() {
 		gc.InitPair(2, gc.C_YELLOW, gc.C_BLUE)
 		gc.InitPair(3, gc.C_RED, gc.C_BLACK)
 
-		commit := selectCommit(stdscr, commits)
-		if commit == nil {
+		repo.Confirm = func(result *RewriteResult) bool {
+			return confirmRewrite(stdscr, result)
+		}
+
+		for {
+			commits, action := selectCommit(stdscr, commitLog)
+			switch action {
+			case actionEdit:
+				runEdit(stdscr, repo, commits)
+			case actionReplaceIdentity:
+				runReplaceIdentity(stdscr, repo, commits[0])
+			case actionBackups:
+				runUndo(stdscr, repo)
+			case actionBranches:
+				commitLog = runSelectBranch(stdscr, repo, commitLog)
+				continue
+			case actionFirstParent:
+				repo.FirstParent = !repo.FirstParent
+				commitLog = reloadLog(repo, commitLog)
+				continue
+			case actionTags:
+				if !runEditTag(stdscr, repo) {
+					continue
+				}
+			}
 			return
 		}
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "glt: %s\n", err)
+		os.Exit(1)
+	}
+}
 
-		log.Println("Entering Edit")
+func runEdit(stdscr *gc.Window, repo *Repo, commits []*gogit.Commit) {
+	log.Println("Entering Edit")
+	for _, commit := range commits {
 		logCommit(commit)
+	}
 
-		commit = editCommit(stdscr, commit)
-		if commit != nil {
-			log.Println("After Edit")
+	commits = editCommits(stdscr, commits)
+	if commits != nil {
+		log.Println("After Edit")
+		for _, commit := range commits {
 			logCommit(commit)
+		}
 
-			refChange, err := repo.SaveCommitIfModified(commit)
-			if err != nil {
-				log.Fatalf("Error saving commit: %s", err)
-			}
-			if refChange != "" {
-				log.Printf("Successfully saved: %s", refChange)
-			}
-			showResult(stdscr, refChange)
+		result, err := repo.SaveCommitsIfModified(commits)
+		if err != nil {
+			// Held locks or refs moved by another process are not errors of glt
+			log.Printf("Error saving commit: %s", err)
+			showMessage(stdscr, err.Error())
+			return
 		}
+		if result != nil && result.DryRun {
+			showPlan(stdscr, result)
+			return
+		}
+		refChange := ""
+		if result != nil {
+			refChange = result.RefNames()
+			log.Printf("Successfully saved: %s", refChange)
+			runPush(stdscr, repo, result)
+		}
+		showResult(stdscr, refChange)
+	}
+}
+
+func runReplaceIdentity(stdscr *gc.Window, repo *Repo, commit *gogit.Commit) {
+	replacement := editIdentityReplacement(stdscr, commit)
+	if replacement == nil {
+		return
+	}
+	log.Printf("Replacing %s with %s since %s", replacement.from, replacement.to, commit.Oid)
+
+	commits, err := repo.CommitsSince(commit)
+	if err != nil {
+		log.Fatalf("error getting commit log: %v", err)
+	}
+	result, count, err := repo.ReplaceIdentity(commits, replacement.from, replacement.to, replacement.author, replacement.committer)
+	if err != nil {
+		log.Printf("Error saving commits: %s", err)
+		showMessage(stdscr, err.Error())
+		return
+	}
+	if result != nil && result.DryRun {
+		showPlan(stdscr, result)
+		return
+	}
+	refChange := ""
+	if result != nil {
+		refChange = result.RefNames()
+		log.Printf("Successfully saved %d commits: %s", count, refChange)
+		runPush(stdscr, repo, result)
+	}
+	showResult(stdscr, refChange)
+}
+
+// Offers to push the rewritten branch to its upstream, expecting the remote
+// branch where its remote-tracking ref says it is
+func runPush(stdscr *gc.Window, repo *Repo, result *RewriteResult) {
+	if !strings.HasPrefix(result.Ref, "refs/heads/") {
+		return
+	}
+	upstream, err := repo.UpstreamOf(result.Ref)
+	if err != nil {
+		log.Printf("Not offering to push: %s", err)
+		return
+	}
+	lease, err := repo.RemoteTip(upstream)
+	if err != nil {
+		log.Printf("Not offering to push: %s", err)
+		return
+	}
+	if !confirmPush(stdscr, result.Ref, upstream, lease) {
+		return
+	}
+
+	output, err := repo.Push(result.Ref, upstream, lease)
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	title := "Push Succeeded"
+	if err != nil {
+		log.Println(err)
+		title = "Push Failed"
+		lines = append([]string{err.Error(), ""}, lines...)
+	}
+	showLines(stdscr, title, lines)
+}
+
+func runUndo(stdscr *gc.Window, repo *Repo) {
+	backups, err := repo.Backups()
+	if err != nil {
+		log.Fatalf("error listing backups: %v", err)
+	}
+	if len(backups) == 0 {
+		showMessage(stdscr, "No backups.")
+		return
+	}
+
+	backup := selectBackup(stdscr, backups)
+	if backup == nil {
+		return
+	}
+	if _, err := repo.Undo(backup.Id); err != nil {
+		// A ref that moved since the rewrite is not an error of glt
+		log.Printf("Error restoring backup: %s", err)
+		showMessage(stdscr, err.Error())
+		return
+	}
+	if repo.DryRun {
+		var lines []string
+		for _, ref := range backup.Refs {
+			lines = append(lines, fmt.Sprintf("Would restore %s to %s", ref.Name, ref.Oid.String()[:12]))
+		}
+		showLines(stdscr, "Dry Run - No Refs Changed", lines)
+		return
+	}
+	showMessage(stdscr, fmt.Sprintf("Restored backup %s.", backup.Id))
+}
+
+// Lets the user pick an annotated tag and edit it. Returns false if the
+// user went back without editing.
+func runEditTag(stdscr *gc.Window, repo *Repo) bool {
+	tags, err := repo.AnnotatedTags()
+	if err != nil {
+		log.Fatalf("error listing tags: %v", err)
+	}
+	if len(tags) == 0 {
+		showMessage(stdscr, "No annotated tags.")
+		return true
+	}
+
+	tag := selectTag(stdscr, tags)
+	if tag == nil {
+		return false
+	}
+	edited := editTag(stdscr, tag)
+	if edited == nil {
+		return false
+	}
+
+	result, err := repo.SaveTag(tag, edited)
+	if err != nil {
+		log.Printf("Error saving tag: %s", err)
+		showMessage(stdscr, err.Error())
+		return true
+	}
+	if result != nil && result.DryRun {
+		showPlan(stdscr, result)
+		return true
+	}
+	refChange := ""
+	if result != nil {
+		refChange = result.RefNames()
+		log.Printf("Successfully saved: %s", refChange)
+	}
+	showResult(stdscr, refChange)
+	return true
+}
+
+// Lets the user pick the branch to edit and returns its commit log, or log
+// if the user cancelled.
+func runSelectBranch(stdscr *gc.Window, repo *Repo, commitLog *CommitLog) *CommitLog {
+	branches, err := repo.listRefs("refs/heads/")
+	if err != nil {
+		log.Fatalf("error listing branches: %v", err)
+	}
+	if len(branches) == 0 {
+		showMessage(stdscr, "No branches.")
+		return commitLog
+	}
+
+	branch := selectBranch(stdscr, branches, commitLog.Ref)
+	if branch == nil {
+		return commitLog
+	}
+	if err := repo.UseRef(branch.Name); err != nil {
+		log.Fatalf("error selecting branch: %v", err)
+	}
+	return reloadLog(repo, commitLog)
+}
+
+// Lists the target ref of repo again, with the limits of commitLog
+func reloadLog(repo *Repo, commitLog *CommitLog) *CommitLog {
+	newLog, err := repo.NewCommitLog(commitLog.limit, commitLog.since)
+	if err != nil {
+		log.Fatalf("error getting commit log: %v", err)
 	}
-	app.Run(os.Args)
+	newLog.LoadMore(100)
+	return newLog
 }
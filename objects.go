@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Reads the raw contents of a commit object
+func (r *Repo) readCommitData(oid *gogit.Oid) ([]byte, error) {
+	objectType, data, err := r.repository.RawObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	if objectType != gogit.ObjectCommit {
+		return nil, fmt.Errorf("Object %s is not a commit", oid)
+	}
+	return data, nil
+}
+
+// Returns the id data would get as an object of the given type, together
+// with the serialized object
+func hashObject(objectType string, data []byte) (*gogit.Oid, []byte) {
+	var object bytes.Buffer
+	fmt.Fprintf(&object, "%s %d\x00", objectType, len(data))
+	object.Write(data)
+
+	return gogit.NewOidFromArray(sha1.Sum(object.Bytes())), object.Bytes()
+}
+
+// Writes data as a zlib compressed loose object and returns its id.
+// Objects that already exist are left untouched.
+func (r *Repo) writeObject(objectType string, data []byte) (*gogit.Oid, error) {
+	oid, object := hashObject(objectType, data)
+
+	sha := oid.String()
+	dir := filepath.Join(r.repository.Path, "objects", sha[:2])
+	path := filepath.Join(dir, sha[2:])
+	if _, err := os.Stat(path); err == nil {
+		return oid, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := zlib.NewWriter(tmp)
+	if _, err := zw.Write(object); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	return oid, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// A commit or tag replaced by a rewrite. Changes is empty for commits that
+// only got new parents.
+type PlannedCommit struct {
+	OldId   *gogit.Oid
+	NewId   *gogit.Oid
+	Changes []FieldChange
+}
+
+const planDateLayout = "2006-01-02 15:04:05 -0700"
+
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// Describes every commit replaced by rw, newest first
+func (r *Repo) planOf(rw *rewriter) ([]*PlannedCommit, error) {
+	plan := make([]*PlannedCommit, len(rw.order))
+	for i, oid := range rw.order {
+		planned := &PlannedCommit{
+			OldId: oid,
+			NewId: rw.rewritten[oid.Bytes],
+		}
+		if edit, ok := rw.edits[oid.Bytes]; ok {
+			original, err := r.repository.LookupCommit(oid)
+			if err != nil {
+				return nil, fmt.Errorf("Error finding matching commit: %s", err)
+			}
+			planned.Changes = diffCommits(original, edit)
+		}
+		plan[len(plan)-1-i] = planned
+	}
+	return plan, nil
+}
+
+func diffSignature(changes []FieldChange, role string, before, after *gogit.Signature) []FieldChange {
+	if before.Name != after.Name {
+		changes = append(changes, FieldChange{role + " name", before.Name, after.Name})
+	}
+	if before.Email != after.Email {
+		changes = append(changes, FieldChange{role + " email", before.Email, after.Email})
+	}
+	if !sameTime(before.When, after.When) {
+		changes = append(changes, FieldChange{role + " date", before.When.Format(planDateLayout), after.When.Format(planDateLayout)})
+	}
+	return changes
+}
+
+func diffCommits(before, after *gogit.Commit) []FieldChange {
+	var changes []FieldChange
+	changes = diffSignature(changes, "author", before.Author, after.Author)
+	changes = diffSignature(changes, "committer", before.Committer, after.Committer)
+	return diffMessage(changes, before.CommitMessage, after.CommitMessage)
+}
+
+// Compares the tagger and message of two tags, ignoring signatures
+func diffTags(before, after *gogit.Tag) []FieldChange {
+	changes := diffSignature(nil, "tagger", before.Tagger, after.Tagger)
+	return diffMessage(changes, tagMessage(before), tagMessage(after))
+}
+
+func diffMessage(changes []FieldChange, before, after string) []FieldChange {
+	if before != after {
+		subjectBefore := strings.Split(before, "\n")[0]
+		subjectAfter := strings.Split(after, "\n")[0]
+		if subjectBefore == subjectAfter {
+			changes = append(changes, FieldChange{"message body", "", "edited"})
+		} else {
+			changes = append(changes, FieldChange{"message", subjectBefore, subjectAfter})
+		}
+	}
+	return changes
+}
+
+// Formats the plan of a result as a table of old id, new id and changes,
+// followed by the moved refs
+func formatPlan(result *RewriteResult) []string {
+	verb := "moved"
+	if result.DryRun {
+		verb = "would move"
+	}
+	lines := formatPlanTable(result)
+	lines = append(lines, "")
+	lines = append(lines, formatRefUpdates(result, verb)...)
+	if len(result.Published) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, formatPublished(result)...)
+	}
+	if len(result.UnsignedTags) > 0 {
+		verb = "dropped"
+		if result.DryRun {
+			verb = "would be dropped"
+		}
+		lines = append(lines, "", formatUnsignedTags(result, verb))
+	}
+	return lines
+}
+
+func formatPlanTable(result *RewriteResult) []string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OLD\tNEW\tCHANGES")
+	for _, planned := range result.Plan {
+		oldId, newId := planned.OldId.String()[:12], planned.NewId.String()[:12]
+		if len(planned.Changes) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\tnew parents\n", oldId, newId)
+			continue
+		}
+		for i, change := range planned.Changes {
+			description := fmt.Sprintf("%s: %q -> %q", change.Field, change.Before, change.After)
+			if change.Before == "" {
+				description = fmt.Sprintf("%s %s", change.Field, change.After)
+			}
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", oldId, newId, description)
+			} else {
+				fmt.Fprintf(tw, "\t\t%s\n", description)
+			}
+		}
+	}
+	tw.Flush()
+
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// Formats one line per moved ref, e.g. "refs/heads/main would move: a -> b"
+func formatRefUpdates(result *RewriteResult, verb string) []string {
+	var lines []string
+	for _, ref := range result.Refs {
+		lines = append(lines, fmt.Sprintf("%s %s: %s -> %s", ref.Name, verb, ref.Old.String()[:12], ref.New.String()[:12]))
+	}
+	return lines
+}
+
+// Formats the annotated tags that lose their signature, e.g.
+// "Signature dropped from: refs/tags/v1.0"
+func formatUnsignedTags(result *RewriteResult, verb string) string {
+	return fmt.Sprintf("Signature %s from: %s", verb, strings.Join(result.UnsignedTags, ", "))
+}
+
+// Formats a warning about the edited commits that are already on a remote
+func formatPublished(result *RewriteResult) []string {
+	lines := []string{"Already on a remote, the rewrite will need a force push:"}
+	for _, published := range result.Published {
+		lines = append(lines, fmt.Sprintf("  %s on %s", published.Oid.String()[:12], strings.Join(published.Remotes, ", ")))
+	}
+	return lines
+}
+
+// Describes a rewrite for reflogs, e.g. "glt: edit author of abc1234" or
+// "glt: edit author and message of 3 commits"
+func reflogMessage(result *RewriteResult) string {
+	var edited []*PlannedCommit
+	var parts []string
+	for _, planned := range result.Plan {
+		if len(planned.Changes) == 0 {
+			continue
+		}
+		edited = append(edited, planned)
+		for _, change := range planned.Changes {
+			part := strings.Fields(change.Field)[0]
+			known := false
+			for _, p := range parts {
+				known = known || p == part
+			}
+			if !known {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if len(edited) == 0 {
+		return "glt: rewrite"
+	}
+
+	subject := edited[0].OldId.String()[:7]
+	if len(edited) > 1 {
+		subject = fmt.Sprintf("%d commits", len(edited))
+	}
+	fields := parts[len(parts)-1]
+	if len(parts) > 1 {
+		fields = strings.Join(parts[:len(parts)-1], ", ") + " and " + fields
+	}
+	return fmt.Sprintf("glt: edit %s of %s", fields, subject)
+}
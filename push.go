@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Where a local branch is pushed to, from branch.<name>.remote and
+// branch.<name>.merge, and the remote-tracking ref that records where the
+// remote branch was at the last fetch or push
+type Upstream struct {
+	Remote   string
+	Ref      string
+	Tracking string
+}
+
+func (u *Upstream) String() string {
+	return u.Remote + " " + u.Ref
+}
+
+// Returns a git command run in the repository
+func (r *Repo) gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.workTree
+	cmd.Env = append(os.Environ(), "GIT_DIR="+r.gitDir)
+	if r.workTree == "" {
+		cmd.Dir = r.gitDir
+	} else {
+		cmd.Env = append(cmd.Env, "GIT_WORK_TREE="+r.workTree)
+	}
+	return cmd
+}
+
+// Returns the configured upstream of the branch ref name
+func (r *Repo) UpstreamOf(name string) (*Upstream, error) {
+	branch := strings.TrimPrefix(name, "refs/heads/")
+	remote, err := r.gitCommand("config", "--get", "branch."+branch+".remote").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s has no upstream branch", branch)
+	}
+	merge, err := r.gitCommand("config", "--get", "branch."+branch+".merge").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s has no upstream branch", branch)
+	}
+	tracking, err := r.gitCommand("for-each-ref", "--format=%(upstream)", name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the upstream of %s: %s", branch, err)
+	}
+	return &Upstream{
+		Remote:   strings.TrimSpace(string(remote)),
+		Ref:      strings.TrimSpace(string(merge)),
+		Tracking: strings.TrimSpace(string(tracking)),
+	}, nil
+}
+
+// Returns where the remote branch of upstream was at the last fetch or
+// push, as recorded by its remote-tracking ref
+func (r *Repo) RemoteTip(upstream *Upstream) (*gogit.Oid, error) {
+	if upstream.Tracking == "" || !strings.HasPrefix(upstream.Tracking, "refs/remotes/") {
+		return nil, fmt.Errorf("%s has no remote-tracking branch", upstream)
+	}
+	oid := r.readRef(upstream.Tracking)
+	if oid == nil {
+		return nil, fmt.Errorf("No remote-tracking branch %s, fetch %s first", shortRefName(upstream.Tracking), upstream.Remote)
+	}
+	return oid, nil
+}
+
+// The arguments of git push for pushing the branch ref name to upstream,
+// forced only while the remote branch is still at lease.
+func pushArgs(name string, upstream *Upstream, lease *gogit.Oid) []string {
+	return []string{
+		"push",
+		fmt.Sprintf("--force-with-lease=%s:%s", upstream.Ref, lease),
+		upstream.Remote,
+		name + ":" + upstream.Ref,
+	}
+}
+
+// Pushes the rewritten branch ref name to upstream with --force-with-lease,
+// expecting the remote branch at lease, its remote-tracking tip. Returns the
+// output of git push.
+func (r *Repo) Push(name string, upstream *Upstream, lease *gogit.Oid) (string, error) {
+	output, err := r.gitCommand(pushArgs(name, upstream, lease)...).CombinedOutput()
+	if err == nil {
+		return string(output), nil
+	}
+	if strings.Contains(string(output), "stale info") {
+		return string(output), fmt.Errorf("Push rejected, %s is no longer at %s as %s says: fetch and check it first", upstream, lease.String()[:12], shortRefName(upstream.Tracking))
+	}
+	return string(output), fmt.Errorf("Push of %s to %s failed: %s", shortRefName(name), upstream, err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPushWithLease(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git init -q --bare ../remote.git
+		git remote add origin ../remote.git
+		git push -q -u origin main
+		git branch local`)
+	remote := filepath.Join(filepath.Dir(dir), "remote.git")
+	repo.AllowPublished = true
+
+	if _, err := repo.UpstreamOf("refs/heads/local"); err == nil || !strings.Contains(err.Error(), "local has no upstream branch") {
+		t.Errorf("UpstreamOf(local) = %v", err)
+	}
+	upstream, err := repo.UpstreamOf("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *upstream != (Upstream{Remote: "origin", Ref: "refs/heads/main", Tracking: "refs/remotes/origin/main"}) {
+		t.Errorf("UpstreamOf(main) = %+v", *upstream)
+	}
+
+	// The rewritten branch replaces the one it was pushed as
+	commit := mustResolve(t, repo, "main~1")
+	commit.Author.Name = "Edited"
+	result, err := repo.SaveCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := repo.RemoteTip(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Push(result.Ref, upstream, lease); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, remote, "git rev-parse main"); got != result.NewTip.String() {
+		t.Errorf("remote main at %s, want %s", got, result.NewTip)
+	}
+	if got := runGit(t, dir, "git rev-parse origin/main"); got != result.NewTip.String() {
+		t.Errorf("origin/main at %s, want %s", got, result.NewTip)
+	}
+
+	// Someone else pushes to the remote, unseen by origin/main
+	runGit(t, filepath.Dir(dir), `
+		git clone -q -b main remote.git other
+		cd other
+		git commit -q --allow-empty -m theirs
+		git push -q origin main`)
+	theirs := runGit(t, remote, "git rev-parse main")
+
+	commit = mustResolve(t, repo, "main")
+	commit.Author.Name = "Edited again"
+	if result, err = repo.SaveCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if lease, err = repo.RemoteTip(upstream); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Push(result.Ref, upstream, lease); err == nil || !strings.HasPrefix(err.Error(), "Push rejected, origin refs/heads/main is no longer at") {
+		t.Errorf("Push() = %v, want a rejected push", err)
+	}
+	if got := runGit(t, remote, "git rev-parse main"); got != theirs {
+		t.Errorf("remote main overwritten: %s, want %s", got, theirs)
+	}
+}
+
+func TestRemoteTipWithoutFetch(t *testing.T) {
+	repo, _ := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git init -q --bare ../remote.git
+		git remote add origin ../remote.git
+		git config branch.main.remote origin
+		git config branch.main.merge refs/heads/main`)
+	upstream, err := repo.UpstreamOf("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RemoteTip(upstream); err == nil || !strings.Contains(err.Error(), "fetch origin first") {
+		t.Errorf("RemoteTip() = %v, want a fetch error", err)
+	}
+}
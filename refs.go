@@ -0,0 +1,507 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returns the name of the ref HEAD points to ("HEAD" when detached) and the
+// commit it resolves to.
+func (r *Repo) resolveHead() (string, *gogit.Oid, error) {
+	content, err := ioutil.ReadFile(r.refPath("HEAD"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	content = bytes.TrimSpace(content)
+	if !bytes.HasPrefix(content, []byte("ref: ")) {
+		oid, err := gogit.NewOidFromByteString(content)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid HEAD: %s", err)
+		}
+		return "HEAD", oid, nil
+	}
+
+	name := string(content[len("ref: "):])
+	oid, err := r.lookupRef(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, oid, nil
+}
+
+// Returns the object the ref name points to, following symbolic refs. Like
+// git, the loose ref file is read first and packed-refs second. gogit's
+// LookupReference is not used as it prefers info/refs, which is only updated
+// by git update-server-info and may be long out of date.
+func (r *Repo) lookupRef(name string) (*gogit.Oid, error) {
+	for depth := 0; depth < 5; depth++ {
+		content, err := ioutil.ReadFile(r.refPath(name))
+		if err == nil {
+			content = bytes.TrimSpace(content)
+			if bytes.HasPrefix(content, []byte("ref: ")) {
+				name = string(content[len("ref: "):])
+				continue
+			}
+			oid, err := gogit.NewOidFromByteString(content)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ref %s: %s", name, err)
+			}
+			return oid, nil
+		}
+		if info, statErr := os.Stat(r.refPath(name)); statErr == nil && !info.IsDir() {
+			return nil, err
+		}
+
+		packed, err := r.readPackedRefs()
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range packed {
+			if ref.Name == name {
+				return ref.Oid, nil
+			}
+		}
+		return nil, fmt.Errorf("No ref named %s", name)
+	}
+	return nil, fmt.Errorf("Too many levels of symbolic refs at %s", name)
+}
+
+// Maps commits to the refs pointing at them, named like the decorations of
+// git log: "HEAD -> main", "feature", "origin/main" or "tag: v1.0".
+// Annotated tags decorate the commit they tag.
+func (r *Repo) decorations() (map[gogit.SHA1][]string, error) {
+	decorations := make(map[gogit.SHA1][]string)
+
+	headName, head, err := r.resolveHead()
+	if err == nil {
+		if headName == "HEAD" {
+			decorations[head.Bytes] = append(decorations[head.Bytes], "HEAD")
+		} else {
+			decorations[head.Bytes] = append(decorations[head.Bytes], "HEAD -> "+shortRefName(headName))
+		}
+	}
+
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/", "refs/tags/"} {
+		refs, err := r.listRefs(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if ref.Name == headName {
+				continue
+			}
+			oid := ref.Oid
+			if objectType, err := r.repository.Type(oid); err == nil && objectType == gogit.ObjectTag {
+				tag, err := r.repository.LookupTag(oid)
+				if err != nil {
+					continue
+				}
+				oid = tag.TargetId
+			}
+			name := strings.TrimPrefix(ref.Name, prefix)
+			if prefix == "refs/tags/" {
+				name = "tag: " + name
+			}
+			decorations[oid.Bytes] = append(decorations[oid.Bytes], name)
+		}
+	}
+	return decorations, nil
+}
+
+// Returns name without its refs/heads/ or refs/ prefix
+func shortRefName(name string) string {
+	if strings.HasPrefix(name, "refs/heads/") {
+		return strings.TrimPrefix(name, "refs/heads/")
+	}
+	return strings.TrimPrefix(name, "refs/")
+}
+
+// Makes rewrites update the ref name ("feature", "heads/feature" or
+// "refs/heads/feature") instead of the ref HEAD points to
+func (r *Repo) UseRef(name string) error {
+	candidates := []string{"refs/heads/" + name, "refs/" + name}
+	if strings.HasPrefix(name, "refs/") {
+		candidates = []string{name}
+	}
+	for _, candidate := range candidates {
+		oid, err := r.lookupRef(candidate)
+		if err != nil {
+			continue
+		}
+		if objectType, err := r.repository.Type(oid); err != nil || objectType != gogit.ObjectCommit {
+			return fmt.Errorf("%s does not point at a commit", candidate)
+		}
+		r.Ref = candidate
+		return nil
+	}
+	return fmt.Errorf("No branch or ref named %s", name)
+}
+
+// Returns the ref rewrites update and the commit it points to: the ref set
+// with UseRef, or else the ref HEAD points to.
+func (r *Repo) resolveTarget() (string, *gogit.Oid, error) {
+	if r.Ref == "" {
+		return r.resolveHead()
+	}
+	oid, err := r.lookupRef(r.Ref)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.Ref, oid, nil
+}
+
+// Returns the file of the loose ref name. HEAD belongs to the worktree, all
+// other refs live in the common git directory.
+func (r *Repo) refPath(name string) string {
+	if name == "HEAD" && r.gitDir != "" {
+		return filepath.Join(r.gitDir, "HEAD")
+	}
+	return filepath.Join(r.repository.Path, filepath.FromSlash(name))
+}
+
+// Ref updates applied together like a git ref transaction. Every ref is
+// locked with a <ref>.lock file and checked against its expected old value
+// before any of them is moved.
+type refTransaction struct {
+	repo    *Repo
+	updates []*RefUpdate
+	current []*gogit.Oid
+	locks   []string
+}
+
+// Returns the commit the ref name points to, or nil if it does not exist
+func (r *Repo) readRef(name string) *gogit.Oid {
+	if name == "HEAD" {
+		if _, oid, err := r.resolveHead(); err == nil {
+			return oid
+		}
+		return nil
+	}
+	oid, err := r.lookupRef(name)
+	if err != nil {
+		return nil
+	}
+	return oid
+}
+
+// Locks the refs of updates. Fails without changing anything if a ref is
+// locked by another process, or is no longer at the Old value of its update.
+// Updates with a nil Old move the ref wherever it is.
+func (r *Repo) lockRefs(updates []*RefUpdate) (*refTransaction, error) {
+	tx := &refTransaction{repo: r, updates: updates}
+	for _, update := range updates {
+		lock := r.refPath(update.Name) + ".lock"
+		if err := os.MkdirAll(filepath.Dir(lock), 0755); err != nil {
+			tx.rollback()
+			return nil, err
+		}
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			tx.rollback()
+			if os.IsExist(err) {
+				return nil, fmt.Errorf("Unable to lock %s: %s exists, another git process seems to be running", update.Name, lock)
+			}
+			return nil, fmt.Errorf("Unable to lock %s: %s", update.Name, err)
+		}
+		f.Close()
+		tx.locks = append(tx.locks, lock)
+
+		current := r.readRef(update.Name)
+		if update.Old != nil && (current == nil || !current.Equal(update.Old)) {
+			tx.rollback()
+			moved := "was deleted"
+			if current != nil {
+				moved = "moved to " + current.String()[:12]
+			}
+			return nil, fmt.Errorf("%s %s while glt was rewriting it from %s, aborting without changes", update.Name, moved, update.Old.String()[:12])
+		}
+		tx.current = append(tx.current, current)
+	}
+	return tx, nil
+}
+
+// Releases all locks without moving any ref
+func (tx *refTransaction) rollback() {
+	for _, lock := range tx.locks {
+		if lock != "" {
+			os.Remove(lock)
+		}
+	}
+	tx.locks = nil
+}
+
+// Moves every locked ref and logs the move with message in the reflogs of
+// the ref and, if it is checked out, of HEAD. An empty message skips the
+// reflogs. If a ref cannot be moved, the refs moved before it are put back
+// so that either all refs move or none.
+func (tx *refTransaction) commit(message string) error {
+	defer tx.rollback()
+
+	for i, update := range tx.updates {
+		lock := tx.locks[i]
+		if err := ioutil.WriteFile(lock, []byte(update.New.String()+"\n"), 0644); err != nil {
+			return fmt.Errorf("Error writing %s: %s", lock, err)
+		}
+	}
+
+	for i, update := range tx.updates {
+		if err := os.Rename(tx.locks[i], tx.repo.refPath(update.Name)); err != nil {
+			if restoreErr := tx.restore(i); restoreErr != nil {
+				return fmt.Errorf("Error updating %s: %s, and error restoring the refs moved before it: %s", update.Name, err, restoreErr)
+			}
+			return fmt.Errorf("Error updating %s: %s, no ref was moved", update.Name, err)
+		}
+		// The lock is gone, another process may take it now
+		tx.locks[i] = ""
+	}
+	if message == "" {
+		return nil
+	}
+
+	headName, _, _ := tx.repo.resolveHead()
+	identity := tx.repo.reflogIdentity()
+	for i, update := range tx.updates {
+		names := []string{update.Name}
+		if update.Name == headName && headName != "HEAD" {
+			names = append(names, "HEAD")
+		}
+		for _, name := range names {
+			if err := tx.repo.appendReflog(name, tx.current[i], update.New, identity, message); err != nil {
+				return fmt.Errorf("Error writing the reflog of %s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Puts the first n refs of the transaction back where they were before it
+func (tx *refTransaction) restore(n int) error {
+	for i := 0; i < n; i++ {
+		path := tx.repo.refPath(tx.updates[i].Name)
+		if tx.current[i] == nil {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		lock := path + ".lock"
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(tx.current[i].String() + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(lock, path)
+		}
+		if err != nil {
+			os.Remove(lock)
+			return err
+		}
+	}
+	return nil
+}
+
+// Moves the refs of updates in a single transaction, see lockRefs
+func (r *Repo) updateRefs(updates []*RefUpdate, message string) error {
+	tx, err := r.lockRefs(updates)
+	if err != nil {
+		return err
+	}
+	return tx.commit(message)
+}
+
+// Returns "Name <email> <time> <offset>" of the committer for reflog
+// entries, as configured for git
+func (r *Repo) reflogIdentity() string {
+	if output, err := r.gitCommand("var", "GIT_COMMITTER_IDENT").Output(); err == nil {
+		return strings.TrimSpace(string(output))
+	}
+	now := time.Now()
+	return fmt.Sprintf("glt <glt@localhost> %d %s", now.Unix(), now.Format(offsetLayout))
+}
+
+// Appends a reflog entry for the move of the ref name from old (nil if it
+// did not exist) to new. Like git, only HEAD, branches and refs that
+// already have a reflog are logged.
+func (r *Repo) appendReflog(name string, old, new *gogit.Oid, identity, message string) error {
+	dir := r.repository.Path
+	if name == "HEAD" && r.gitDir != "" {
+		dir = r.gitDir
+	}
+	path := filepath.Join(dir, "logs", filepath.FromSlash(name))
+	if _, err := os.Stat(path); os.IsNotExist(err) && name != "HEAD" && !strings.HasPrefix(name, "refs/heads/") {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	oldId := strings.Repeat("0", 40)
+	if old != nil {
+		oldId = old.String()
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	message = strings.Replace(message, "\n", " ", -1)
+	if _, err := fmt.Fprintf(f, "%s %s %s\t%s\n", oldId, new, identity, message); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Lists all refs whose name starts with prefix, from loose ref files and
+// packed-refs, sorted by name. Loose refs shadow packed ones.
+func (r *Repo) listRefs(prefix string) ([]*gogit.Reference, error) {
+	found := make(map[string]*gogit.Oid)
+
+	packed, err := r.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if strings.HasPrefix(ref.Name, prefix) {
+			found[ref.Name] = ref.Oid
+		}
+	}
+
+	root := filepath.Join(r.repository.Path, filepath.FromSlash(prefix))
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".lock") || strings.HasPrefix(info.Name(), "tmp_ref_") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.repository.Path, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		oid, err := r.lookupRef(name)
+		if err != nil {
+			// Dangling symbolic refs are skipped like git does
+			return nil
+		}
+		found[name] = oid
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]*gogit.Reference, 0, len(found))
+	for name, oid := range found {
+		refs = append(refs, &gogit.Reference{Name: name, Oid: oid})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// Reads all entries of packed-refs. Peeled lines ("^<sha>") are skipped.
+func (r *Repo) readPackedRefs() ([]*gogit.Reference, error) {
+	content, err := ioutil.ReadFile(filepath.Join(r.repository.Path, "packed-refs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*gogit.Reference
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		oid, err := gogit.NewOidFromString(fields[0])
+		if err != nil {
+			continue
+		}
+		refs = append(refs, &gogit.Reference{Name: fields[1], Oid: oid})
+	}
+	return refs, nil
+}
+
+// Deletes the ref name, both the loose file and its packed-refs entry
+func (r *Repo) deleteRef(name string) error {
+	path := r.refPath(name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Clean up directories left empty, up to refs/
+	refsDir := filepath.Join(r.repository.Path, "refs")
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, refsDir+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return r.removePackedRef(name)
+}
+
+func (r *Repo) removePackedRef(name string) error {
+	path := filepath.Join(r.repository.Path, "packed-refs")
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var kept []string
+	removed, skipPeeled := false, false
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if skipPeeled && strings.HasPrefix(line, "^") {
+			continue
+		}
+		skipPeeled = false
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == name && !strings.HasPrefix(line, "#") {
+			removed, skipPeeled = true, true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return nil
+	}
+
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to lock packed-refs: %s", err)
+	}
+	if _, err := f.WriteString(strings.Join(kept, "")); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, path)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// git gc writes info/refs, which later commits and git pack-refs leave
+// behind. Refs must be read from the loose files and packed-refs only.
+func TestRefsIgnoreInfoRefs(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git gc -q
+		git commit -q --allow-empty -m two
+		git commit -q --allow-empty -m three
+		git pack-refs --all`)
+	one := runGit(t, dir, "git rev-parse main~2")
+	tip := runGit(t, dir, "git rev-parse main")
+
+	infoRefs, err := ioutil.ReadFile(filepath.Join(dir, ".git", "info", "refs"))
+	if err != nil || !strings.Contains(string(infoRefs), one+"\trefs/heads/main") {
+		t.Fatalf("git gc did not leave a stale info/refs: %q %v", infoRefs, err)
+	}
+
+	if got := repo.readRef("refs/heads/main"); got == nil || got.String() != tip {
+		t.Errorf("readRef() = %v, want %s", got, tip)
+	}
+	if name, got, err := repo.resolveHead(); err != nil || name != "refs/heads/main" || got.String() != tip {
+		t.Errorf("resolveHead() = %s %v %v, want %s", name, got, err, tip)
+	}
+	if err := repo.UseRef("main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, err := repo.resolveTarget(); err != nil || got.String() != tip {
+		t.Errorf("resolveTarget() = %v %v, want %s", got, err, tip)
+	}
+	if got := mustResolve(t, repo, "main"); got.Oid.String() != tip {
+		t.Errorf("ResolveCommit() = %s, want %s", got.Oid, tip)
+	}
+
+	// A loose ref shadows its packed entry
+	runGit(t, dir, "git update-ref refs/heads/main main~1")
+	if got := repo.readRef("refs/heads/main"); got == nil || got.String() != runGit(t, dir, "git rev-parse main") {
+		t.Errorf("readRef() = %v after update-ref", got)
+	}
+	if got := repo.readRef("refs/heads/missing"); got != nil {
+		t.Errorf("readRef() = %s for a missing ref", got)
+	}
+}
+
+// Lists the lock files left in the refs of dir
+func leftLocks(t *testing.T, dir string) []string {
+	var locks []string
+	filepath.Walk(filepath.Join(dir, ".git"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".lock") {
+			locks = append(locks, path)
+		}
+		return nil
+	})
+	return locks
+}
+
+func TestLockRefsCompareAndSwap(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git branch other HEAD~1`)
+	one, two := mustResolve(t, repo, "main~1").Oid, mustResolve(t, repo, "main").Oid
+
+	for _, c := range []struct {
+		updates []*RefUpdate
+		err     string
+	}{
+		// main is at two, not one
+		{[]*RefUpdate{{Name: "refs/heads/other", Old: one, New: two}, {Name: "refs/heads/main", Old: one, New: one}}, "refs/heads/main moved to " + two.String()[:12]},
+		{[]*RefUpdate{{Name: "refs/heads/gone", Old: one, New: two}}, "refs/heads/gone was deleted"},
+	} {
+		_, err := repo.lockRefs(c.updates)
+		if err == nil || !strings.Contains(err.Error(), c.err) || !strings.Contains(err.Error(), "aborting without changes") {
+			t.Errorf("lockRefs() = %v, want %q", err, c.err)
+		}
+	}
+	if locks := leftLocks(t, dir); len(locks) != 0 {
+		t.Errorf("locks left behind: %v", locks)
+	}
+	if got := runGit(t, dir, "git rev-parse main other"); got != two.String()+"\n"+one.String() {
+		t.Errorf("refs moved: %s", got)
+	}
+
+	// Updates without an old value move the ref wherever it is
+	if err := repo.updateRefs([]*RefUpdate{{Name: "refs/heads/main", New: one}, {Name: "refs/heads/new", New: two}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, dir, "git rev-parse main new"); got != one.String()+"\n"+two.String() {
+		t.Errorf("refs not moved: %s", got)
+	}
+}
+
+func TestLockRefsContention(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		touch .git/refs/heads/main.lock`)
+	one, two := mustResolve(t, repo, "main~1").Oid, mustResolve(t, repo, "main").Oid
+
+	_, err := repo.lockRefs([]*RefUpdate{{Name: "refs/heads/other", New: one}, {Name: "refs/heads/main", Old: two, New: one}})
+	if err == nil || !strings.Contains(err.Error(), "Unable to lock refs/heads/main") {
+		t.Fatalf("lockRefs() = %v, want a lock error", err)
+	}
+	// Only the lock of the other process is left
+	if locks := leftLocks(t, dir); len(locks) != 1 || !strings.HasSuffix(locks[0], "main.lock") {
+		t.Errorf("locks %v", locks)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git", "refs", "heads", "other")); !os.IsNotExist(err) {
+		t.Errorf("refs/heads/other created")
+	}
+
+	// Saving a commit fails the same way, before any backup is made
+	repo.AllowPublished = true
+	commit := mustResolve(t, repo, "main")
+	commit.Author.Name = "Edited"
+	if _, err := repo.SaveCommit(commit); err == nil || !strings.Contains(err.Error(), "Unable to lock") {
+		t.Fatalf("SaveCommit() = %v, want a lock error", err)
+	}
+	if backups, err := repo.Backups(); err != nil || len(backups) != 0 {
+		t.Errorf("backups %v %v", backups, err)
+	}
+	if got := runGit(t, dir, "git rev-parse main"); got != two.String() {
+		t.Errorf("main moved to %s", got)
+	}
+}
+
+// A ref that cannot be moved puts back the refs moved before it
+func TestRefTransactionRestore(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git branch other HEAD~1
+		git pack-refs --all
+		git branch loose HEAD~1`)
+	one, two := mustResolve(t, repo, "main~1").Oid, mustResolve(t, repo, "main").Oid
+	reflogs := runGit(t, dir, "git reflog main | wc -l; git reflog other | wc -l")
+
+	tx, err := repo.lockRefs([]*RefUpdate{
+		{Name: "refs/heads/new", New: two},
+		{Name: "refs/heads/main", Old: two, New: one},
+		{Name: "refs/heads/other", Old: one, New: two},
+		{Name: "refs/heads/loose", Old: one, New: two},
+		{Name: "refs/heads/blocked", New: two},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory in the way of the last ref
+	if err := os.MkdirAll(filepath.Join(dir, ".git", "refs", "heads", "blocked", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = tx.commit("glt: test")
+	if err == nil || !strings.Contains(err.Error(), "Error updating refs/heads/blocked") || !strings.Contains(err.Error(), "no ref was moved") {
+		t.Fatalf("commit() = %v", err)
+	}
+
+	if got := runGit(t, dir, "git rev-parse main other loose"); got != strings.Join([]string{two.String(), one.String(), one.String()}, "\n") {
+		t.Errorf("refs not restored:\n%s", got)
+	}
+	if repo.readRef("refs/heads/new") != nil {
+		t.Error("refs/heads/new not removed")
+	}
+	if locks := leftLocks(t, dir); len(locks) != 0 {
+		t.Errorf("locks left behind: %v", locks)
+	}
+	if got := runGit(t, dir, "git reflog main | wc -l; git reflog other | wc -l"); got != reflogs {
+		t.Errorf("reflogs written for a failed transaction")
+	}
+}
+
+func TestRefTransactionReflog(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git branch other HEAD~1
+		git tag light HEAD~1
+		git config user.name Committer
+		git config user.email committer@example.com`)
+	one, two := mustResolve(t, repo, "main~1").Oid, mustResolve(t, repo, "main").Oid
+
+	updates := []*RefUpdate{
+		{Name: "refs/heads/main", Old: two, New: one},
+		{Name: "refs/heads/other", Old: one, New: two},
+		{Name: "refs/tags/light", Old: one, New: two},
+	}
+	if err := repo.updateRefs(updates, "glt: test\nsecond line"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ ref, old, new string }{
+		{"refs/heads/main", two.String(), one.String()},
+		{"HEAD", two.String(), one.String()},
+		{"refs/heads/other", one.String(), two.String()},
+	} {
+		got := runGit(t, dir, "git reflog -1 --format='%H %gn <%ge> %gs' "+c.ref)
+		if exp := c.new + " Committer <committer@example.com> glt: test second line"; got != exp {
+			t.Errorf("%s reflog %q, want %q", c.ref, got, exp)
+		}
+		line := runGit(t, dir, "tail -1 .git/logs/"+c.ref)
+		if !strings.HasPrefix(line, c.old+" "+c.new+" ") {
+			t.Errorf("%s reflog line %q does not move %s to %s", c.ref, line, c.old[:7], c.new[:7])
+		}
+	}
+	// Tags have no reflog unless one exists already
+	if _, err := os.Stat(filepath.Join(dir, ".git", "logs", "refs", "tags", "light")); !os.IsNotExist(err) {
+		t.Error("reflog written for a tag")
+	}
+
+	// Without a message no reflog is written
+	count := runGit(t, dir, "git reflog main | wc -l")
+	if err := repo.updateRefs([]*RefUpdate{{Name: "refs/heads/main", Old: one, New: two}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, dir, "git reflog main | wc -l"); got != count {
+		t.Errorf("%s reflog entries, want %s", got, count)
+	}
+	runGit(t, dir, "git fsck --strict --no-dangling")
+}
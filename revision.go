@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	revisionSuffix = regexp.MustCompile(`[~^][0-9]*$`)
+	abbreviatedSha = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+)
+
+// Resolves a revision to a commit. Accepted are full and abbreviated SHAs,
+// ref names such as HEAD, master or tags/v1.0, followed by any number of
+// ~<n> and ^<n> suffixes.
+func (r *Repo) ResolveCommit(rev string) (*gogit.Commit, error) {
+	var suffixes []string
+	base := rev
+	for {
+		loc := revisionSuffix.FindStringIndex(base)
+		if loc == nil {
+			break
+		}
+		suffixes = append([]string{base[loc[0]:]}, suffixes...)
+		base = base[:loc[0]]
+	}
+
+	oid, err := r.resolveRevisionBase(base)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := r.repository.LookupCommit(oid)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading commit %s: %s", oid, err)
+	}
+
+	for _, suffix := range suffixes {
+		n := 1
+		if len(suffix) > 1 {
+			n, _ = strconv.Atoi(suffix[1:])
+		}
+		if suffix[0] == '^' {
+			if n == 0 {
+				continue
+			}
+			ci = ci.Parent(n - 1)
+		} else {
+			for i := 0; i < n && ci != nil; i++ {
+				ci = ci.Parent(0)
+			}
+		}
+		if ci == nil {
+			return nil, fmt.Errorf("Revision %s does not exist", rev)
+		}
+	}
+	return ci, nil
+}
+
+func (r *Repo) resolveRevisionBase(name string) (*gogit.Oid, error) {
+	if name == "" {
+		name = "HEAD"
+	}
+	if len(name) == 40 {
+		if oid, err := gogit.NewOidFromString(name); err == nil {
+			return oid, nil
+		}
+	}
+
+	if name == "HEAD" {
+		_, head, err := r.resolveHead()
+		return head, err
+	}
+
+	candidates := []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name}
+	for _, candidate := range candidates {
+		oid, err := r.lookupRef(candidate)
+		if err != nil {
+			continue
+		}
+		return r.peel(oid)
+	}
+
+	if abbreviatedSha.MatchString(name) {
+		return r.findAbbreviated(name)
+	}
+	return nil, fmt.Errorf("Unknown revision %s", name)
+}
+
+// Follows annotated tags to the object they point at
+func (r *Repo) peel(oid *gogit.Oid) (*gogit.Oid, error) {
+	for {
+		objectType, err := r.repository.Type(oid)
+		if err != nil {
+			return nil, err
+		}
+		if objectType != gogit.ObjectTag {
+			return oid, nil
+		}
+		tag, err := r.repository.LookupTag(oid)
+		if err != nil {
+			return nil, err
+		}
+		oid = tag.TargetId
+	}
+}
+
+// Searches the history of HEAD and the target branch for a commit whose id
+// starts with prefix
+func (r *Repo) findAbbreviated(prefix string) (*gogit.Oid, error) {
+	_, head, err := r.resolveHead()
+	if err != nil {
+		return nil, err
+	}
+	_, tip, err := r.resolveTarget()
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.revList([]*gogit.Oid{head, tip}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.ToLower(prefix)
+	var found *gogit.Oid
+	for _, oid := range commits {
+		if !strings.HasPrefix(oid.String(), prefix) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("Short SHA %s is ambiguous", prefix)
+		}
+		found = oid
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("Unknown revision %s", prefix)
+	}
+	return found, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// A rewriter writes new commit objects for a set of edited commits and
+// re-parents every commit that descends from them. In a dry run the new ids
+// are only computed and nothing is written.
+type rewriter struct {
+	repo      *Repo
+	dryRun    bool
+	edits     map[gogit.SHA1]*gogit.Commit
+	rewritten map[gogit.SHA1]*gogit.Oid
+	order     []*gogit.Oid // replaced commits, parents first
+}
+
+func newRewriter(repo *Repo) *rewriter {
+	return &rewriter{
+		repo:      repo,
+		edits:     make(map[gogit.SHA1]*gogit.Commit),
+		rewritten: make(map[gogit.SHA1]*gogit.Oid),
+	}
+}
+
+// Registers the new metadata of a commit, keyed by its original id
+func (rw *rewriter) edit(commit *gogit.Commit) {
+	rw.edits[commit.Oid.Bytes] = commit
+}
+
+// Returns the id that replaces oid after rewriting all of its ancestors.
+// Commits which neither are edited nor have a rewritten parent keep their id.
+func (rw *rewriter) rewrite(oid *gogit.Oid) (*gogit.Oid, error) {
+	stack := []*gogit.Oid{oid}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		if _, done := rw.rewritten[current.Bytes]; done {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		data, err := rw.repo.readCommitData(current)
+		if err != nil {
+			return nil, err
+		}
+		parents, err := parseCommitParents(data)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parents have to be rewritten first, revisit this commit afterwards
+		pending := false
+		for _, parent := range parents {
+			if _, done := rw.rewritten[parent.Bytes]; !done {
+				stack = append(stack, parent)
+				pending = true
+			}
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+
+		changed := false
+		newParents := make([]*gogit.Oid, len(parents))
+		for i, parent := range parents {
+			newParents[i] = rw.rewritten[parent.Bytes]
+			if !newParents[i].Equal(parent) {
+				changed = true
+			}
+		}
+
+		edit, edited := rw.edits[current.Bytes]
+		if !changed && !edited {
+			rw.rewritten[current.Bytes] = current
+			continue
+		}
+
+		var newOid *gogit.Oid
+		newData := rewriteCommitData(data, newParents, edit)
+		if rw.dryRun {
+			newOid, _ = hashObject("commit", newData)
+		} else if newOid, err = rw.repo.writeObject("commit", newData); err != nil {
+			return nil, fmt.Errorf("Error writing commit: %s", err)
+		}
+		rw.rewritten[current.Bytes] = newOid
+		rw.order = append(rw.order, current)
+	}
+
+	return rw.rewritten[oid.Bytes], nil
+}
+
+// Checks that a signature can be written into a commit header as is. Values
+// are never passed through a shell, so only the header syntax matters.
+func checkSignature(role string, sig *gogit.Signature) error {
+	if strings.ContainsAny(sig.Name, "<>\n") {
+		return fmt.Errorf("%s name %q may not contain '<', '>' or newlines", role, sig.Name)
+	}
+	if strings.ContainsAny(sig.Email, "<>\n") {
+		return fmt.Errorf("%s email %q may not contain '<', '>' or newlines", role, sig.Email)
+	}
+	return nil
+}
+
+func formatSignature(sig *gogit.Signature) string {
+	return fmt.Sprintf("%s <%s> %d %s", sig.Name, sig.Email, sig.When.Unix(), sig.When.Format(offsetLayout))
+}
+
+// Splits raw commit data into header lines and the message. Continuation
+// lines of multi-line headers (e.g. gpgsig) stay attached to their header.
+func splitCommitData(data []byte) ([][]byte, []byte) {
+	var headers [][]byte
+	rest := data
+	for len(rest) > 0 {
+		eol := bytes.IndexByte(rest, '\n')
+		if eol < 0 {
+			eol = len(rest) - 1
+		}
+		if eol == 0 {
+			return headers, rest[1:]
+		}
+		line := rest[:eol+1]
+		if line[0] == ' ' && len(headers) > 0 {
+			headers[len(headers)-1] = append(headers[len(headers)-1], line...)
+		} else {
+			headers = append(headers, append([]byte(nil), line...))
+		}
+		rest = rest[eol+1:]
+	}
+	return headers, nil
+}
+
+func headerKey(header []byte) string {
+	if end := bytes.IndexAny(header, " \n"); end >= 0 {
+		return string(header[:end])
+	}
+	return string(header)
+}
+
+func parseCommitParents(data []byte) ([]*gogit.Oid, error) {
+	headers, _ := splitCommitData(data)
+	var parents []*gogit.Oid
+	for _, header := range headers {
+		if headerKey(header) != "parent" {
+			continue
+		}
+		oid, err := gogit.NewOidFromByteString(bytes.TrimSpace(header[len("parent "):]))
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, oid)
+	}
+	return parents, nil
+}
+
+// Returns a copy of the raw commit data pointing at new parents. If edit is
+// set, author, committer and message are taken from it. Any signature is
+// dropped as it would no longer match the rewritten commit.
+func rewriteCommitData(data []byte, parents []*gogit.Oid, edit *gogit.Commit) []byte {
+	headers, message := splitCommitData(data)
+
+	// Edited values are UTF-8, so the encoding header goes unless a value
+	// kept from the commit cannot be converted
+	keepEncoding := edit == nil
+	if edit != nil {
+		encoding := ""
+		for _, header := range headers {
+			if headerKey(header) == "encoding" {
+				encoding = strings.TrimSpace(string(header[len("encoding"):]))
+			}
+		}
+		edit = copyCommit(edit)
+		values := []*string{&edit.Author.Name, &edit.Author.Email, &edit.Committer.Name, &edit.Committer.Email, &edit.CommitMessage}
+		for _, value := range values {
+			*value = toUTF8(*value, encoding)
+			if !utf8.ValidString(*value) {
+				keepEncoding = true
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, header := range headers {
+		switch headerKey(header) {
+		case "encoding":
+			if keepEncoding {
+				buf.Write(header)
+			}
+		case "tree":
+			buf.Write(header)
+			for _, parent := range parents {
+				fmt.Fprintf(&buf, "parent %s\n", parent)
+			}
+		case "parent", "gpgsig", "gpgsig-sha256":
+		case "author":
+			if edit != nil {
+				fmt.Fprintf(&buf, "author %s\n", formatSignature(edit.Author))
+			} else {
+				buf.Write(header)
+			}
+		case "committer":
+			if edit != nil {
+				fmt.Fprintf(&buf, "committer %s\n", formatSignature(edit.Committer))
+			} else {
+				buf.Write(header)
+			}
+		default:
+			buf.Write(header)
+		}
+	}
+	buf.WriteByte('\n')
+	if edit != nil {
+		buf.WriteString(edit.CommitMessage)
+	} else {
+		buf.Write(message)
+	}
+
+	return buf.Bytes()
+}
+
+// Converts value from the ISO-8859-1 encoding of a commit to UTF-8. Values
+// that are valid UTF-8 already, or in another encoding, are left as they are.
+func toUTF8(value, encoding string) string {
+	if utf8.ValidString(value) {
+		return value
+	}
+	switch strings.ToLower(encoding) {
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+	default:
+		return value
+	}
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+	return string(runes)
+}
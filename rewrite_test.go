@@ -0,0 +1,324 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runGit runs a shell script using git in dir with a fixed identity and no
+// user configuration, and returns its trimmed output.
+func runGit(tb testing.TB, dir, script string) string {
+	cmd := exec.Command("sh", "-ec", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_AUTHOR_NAME=Author", "GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=Committer", "GIT_COMMITTER_EMAIL=committer@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tb.Fatalf("%s: %v\n%s", script, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testRepo creates a repository in a temporary directory with script and
+// opens it.
+func testRepo(tb testing.TB, script string) (*Repo, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		tb.Skip("git not found")
+	}
+	dir := tb.TempDir()
+	runGit(tb, dir, "git init -q . && git symbolic-ref HEAD refs/heads/main && "+script)
+	repo, err := OpenRepository(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return repo, dir
+}
+
+// mustOid calls NewOidFromString and calls tb.Fatal in case of error.
+func mustOid(tb testing.TB, sha1 string) *gogit.Oid {
+	oid, err := gogit.NewOidFromString(sha1)
+	if err != nil {
+		tb.Fatalf("NewOidFromString(%q) failed: %v", sha1, err)
+	}
+	return oid
+}
+
+// mustResolve calls ResolveCommit and calls tb.Fatal in case of error.
+func mustResolve(tb testing.TB, repo *Repo, rev string) *gogit.Commit {
+	commit, err := repo.ResolveCommit(rev)
+	if err != nil {
+		tb.Fatalf("ResolveCommit(%q) failed: %v", rev, err)
+	}
+	return commit
+}
+
+const signedCommit = "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+	"parent 1111111111111111111111111111111111111111\n" +
+	"author A U Thor <author@example.com> 1500000000 +0200\n" +
+	"committer C O Mitter <committer@example.com> 1500000001 -0130\n" +
+	"encoding ISO-8859-1\n" +
+	"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+	" \n" +
+	" iQEzBAABCAAdFiEE\n" +
+	" -----END PGP SIGNATURE-----\n" +
+	"x-custom  two  spaces \n" +
+	"\n" +
+	"Subject  \n" +
+	"\n" +
+	"Body without a final newline"
+
+func TestSplitCommitData(t *testing.T) {
+	headers, message := splitCommitData([]byte(signedCommit))
+	keys := make([]string, len(headers))
+	for i, header := range headers {
+		keys[i] = headerKey(header)
+	}
+	if got, exp := strings.Join(keys, ","), "tree,parent,author,committer,encoding,gpgsig,x-custom"; got != exp {
+		t.Errorf("header keys %s, want %s", got, exp)
+	}
+	if got := string(headers[5]); !strings.HasSuffix(got, " -----END PGP SIGNATURE-----\n") || strings.Count(got, "\n") != 4 {
+		t.Errorf("gpgsig continuation lines not kept together: %q", got)
+	}
+	if got, exp := string(message), "Subject  \n\nBody without a final newline"; got != exp {
+		t.Errorf("message %q, want %q", got, exp)
+	}
+
+	headers, message = splitCommitData([]byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\n"))
+	if len(headers) != 1 || len(message) != 0 {
+		t.Errorf("empty message: %q %q", headers, message)
+	}
+}
+
+func TestRewriteCommitDataKeepsUneditedBytes(t *testing.T) {
+	parents := []*gogit.Oid{
+		mustOid(t, "2222222222222222222222222222222222222222"),
+		mustOid(t, "3333333333333333333333333333333333333333"),
+	}
+	got := string(rewriteCommitData([]byte(signedCommit), parents, nil))
+	exp := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"parent 3333333333333333333333333333333333333333\n" +
+		"author A U Thor <author@example.com> 1500000000 +0200\n" +
+		"committer C O Mitter <committer@example.com> 1500000001 -0130\n" +
+		"encoding ISO-8859-1\n" +
+		"x-custom  two  spaces \n" +
+		"\n" +
+		"Subject  \n" +
+		"\n" +
+		"Body without a final newline"
+	if got != exp {
+		t.Errorf("rewriteCommitData() =\n%s\nwant\n%s", got, exp)
+	}
+
+	// Without new parents the commit becomes a root commit
+	if got := string(rewriteCommitData([]byte(signedCommit), nil, nil)); strings.Contains(got, "parent ") {
+		t.Errorf("parents kept:\n%s", got)
+	}
+}
+
+func TestRewriteCommitDataEdit(t *testing.T) {
+	repo, _ := testRepo(t, "git commit -q --allow-empty -m one")
+	edit := mustResolve(t, repo, "HEAD")
+	edit.Author.Name = "New Author"
+	edit.CommitMessage = "New message\n"
+
+	got := string(rewriteCommitData([]byte(signedCommit), nil, edit))
+	for _, line := range []string{
+		"author New Author <author@example.com> ",
+		"committer Committer <committer@example.com> ",
+		"x-custom  two  spaces \n",
+		"\n\nNew message\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("%q missing from\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "gpgsig") || strings.Contains(got, "PGP") {
+		t.Errorf("signature kept:\n%s", got)
+	}
+	// The edited values are UTF-8
+	if strings.Contains(got, "encoding") {
+		t.Errorf("encoding kept:\n%s", got)
+	}
+
+	// Values kept from an ISO-8859-1 commit are converted to UTF-8
+	latin1 := strings.Replace(signedCommit, "A U Thor", "Zo\xeb", 1)
+	edit.Author.Name = "Zo\xeb"
+	edit.Committer.Name = "Jos\xe9"
+	edit.CommitMessage = "Caf\xe9 \xfcber alles\n"
+	got = string(rewriteCommitData([]byte(latin1), nil, edit))
+	for _, line := range []string{"author Zoë <", "committer José <", "\n\nCafé über alles\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("%q missing from\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "encoding") {
+		t.Errorf("encoding kept:\n%s", got)
+	}
+	if edit.Author.Name != "Zo\xeb" {
+		t.Errorf("edit changed to %q", edit.Author.Name)
+	}
+
+	// Other encodings cannot be converted and stay declared
+	sjis := strings.Replace(signedCommit, "ISO-8859-1", "Shift_JIS", 1)
+	edit.CommitMessage = "\x93\xfa\x96\x7b\n"
+	if got := string(rewriteCommitData([]byte(sjis), nil, edit)); !strings.Contains(got, "encoding Shift_JIS\n") {
+		t.Errorf("encoding dropped:\n%s", got)
+	}
+
+	sha256Signed := strings.Replace(signedCommit, "gpgsig ", "gpgsig-sha256 ", 1)
+	if got := string(rewriteCommitData([]byte(sha256Signed), nil, nil)); strings.Contains(got, "PGP") {
+		t.Errorf("gpgsig-sha256 kept:\n%s", got)
+	}
+}
+
+func TestRewriteAcrossMerges(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m base
+		git commit -q --allow-empty -m main1
+		git checkout -q -b side HEAD~1
+		git commit -q --allow-empty -m side1
+		git checkout -q main
+		git merge -q --no-ff -m merge side
+		git commit -q --allow-empty -m top`)
+
+	for _, c := range []struct {
+		edit string
+		// Parents of the rewritten merge that must have changed
+		first, second bool
+	}{
+		{"main~2", true, false},
+		{"side", false, true},
+		{"main~3", true, true},
+	} {
+		edited := mustResolve(t, repo, c.edit)
+		edited.Author.Name = "Edited"
+		merge := mustResolve(t, repo, "main~1")
+		tip := mustResolve(t, repo, "main")
+
+		rw := newRewriter(repo)
+		rw.edit(edited)
+		newTip, err := rw.rewrite(tip.Oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newMerge := rw.rewritten[merge.Oid.Bytes]
+		if newTip.Equal(tip.Oid) || newMerge == nil || newMerge.Equal(merge.Oid) {
+			t.Fatalf("%s: merge or tip not rewritten", c.edit)
+		}
+
+		oldParents := strings.Fields(runGit(t, dir, "git log -1 --format=%P "+merge.Oid.String()))
+		newParents := strings.Fields(runGit(t, dir, "git log -1 --format=%P "+newMerge.String()))
+		if len(newParents) != 2 {
+			t.Fatalf("%s: rewritten merge has parents %v", c.edit, newParents)
+		}
+		if (oldParents[0] != newParents[0]) != c.first || (oldParents[1] != newParents[1]) != c.second {
+			t.Errorf("%s: merge parents %v became %v", c.edit, oldParents, newParents)
+		}
+		if got := runGit(t, dir, "git log -1 --format=%P "+newTip.String()); got != newMerge.String() {
+			t.Errorf("%s: tip not re-parented onto the merge: %s", c.edit, got)
+		}
+		if got := runGit(t, dir, "git log --format=%an "+newTip.String()+" | grep -c Edited"); got != "1" {
+			t.Errorf("%s: %s commits edited", c.edit, got)
+		}
+	}
+	runGit(t, dir, "git fsck --strict --no-dangling")
+}
+
+// Names and emails that have to reach the commit object as they are
+var awkwardSignatures = []struct{ name, email string }{
+	{"O'Brien $x `id`", "o'brien@example.com"},
+	{`Jane "JD" Doe \n; rm -rf /`, "jane+$HOME@example.com"},
+	{"Zoë Ñúñez-Þórsdóttir", "zoe@exämple.com"},
+	{"山田 太郎", "taro@例え.jp"},
+}
+
+func TestCheckSignature(t *testing.T) {
+	for _, s := range awkwardSignatures {
+		sig := &gogit.Signature{Name: s.name, Email: s.email}
+		if err := checkSignature("Author", sig); err != nil {
+			t.Errorf("checkSignature(%q, %q) failed: %v", s.name, s.email, err)
+		}
+	}
+
+	for _, s := range []struct{ name, email string }{
+		{"Evil <evil@example.com>", "a@example.com"},
+		{"Evil>", "a@example.com"},
+		{"Two\nLines", "a@example.com"},
+		{"Name", "a@example.com> 0 +0000\nauthor x <y"},
+		{"Name", "<a@example.com"},
+		{"Name", "a@example.com\n"},
+	} {
+		sig := &gogit.Signature{Name: s.name, Email: s.email}
+		if err := checkSignature("Author", sig); err == nil {
+			t.Errorf("checkSignature(%q, %q) accepted", s.name, s.email)
+		}
+	}
+}
+
+func TestFormatSignature(t *testing.T) {
+	for _, c := range []struct {
+		offset int
+		exp    string
+	}{
+		{0, "O'Brien $x `id` <o'brien@example.com> 1500000000 +0000"},
+		{5*3600 + 30*60, "O'Brien $x `id` <o'brien@example.com> 1500000000 +0530"},
+		{-(1*3600 + 30*60), "O'Brien $x `id` <o'brien@example.com> 1500000000 -0130"},
+	} {
+		sig := &gogit.Signature{
+			Name:  awkwardSignatures[0].name,
+			Email: awkwardSignatures[0].email,
+			When:  time.Unix(1500000000, 0).In(time.FixedZone("", c.offset)),
+		}
+		if got := formatSignature(sig); got != c.exp {
+			t.Errorf("formatSignature() = %q, want %q", got, c.exp)
+		}
+	}
+}
+
+// Writes commits with awkward signatures and checks that git reads back
+// exactly what was entered.
+func TestSignatureRoundTrip(t *testing.T) {
+	repo, dir := testRepo(t, "git commit -q --allow-empty -m one")
+	_, data, err := repo.repository.RawObject(mustResolve(t, repo, "HEAD").Oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range awkwardSignatures {
+		edit := mustResolve(t, repo, "HEAD")
+		edit.Author.Name, edit.Author.Email = s.name, s.email
+		edit.Author.When = time.Unix(1500000000, 0).In(time.FixedZone("", -(3*3600 + 30*60)))
+		edit.Committer.Name = s.name
+		edit.CommitMessage = "Message by " + s.name + "\n"
+		if err := checkSignature("Author", edit.Author); err != nil {
+			t.Fatal(err)
+		}
+
+		oid, err := repo.writeObject("commit", rewriteCommitData(data, nil, edit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := runGit(t, dir, "git log -1 --format='%an%n%ae%n%ad%n%cn%n%s' --date=raw "+oid.String())
+		exp := strings.Join([]string{s.name, s.email, "1500000000 -0330", s.name, "Message by " + s.name}, "\n")
+		if got != exp {
+			t.Errorf("git read back\n%s\nwant\n%s", got, exp)
+		}
+
+		commit, err := repo.repository.LookupCommit(oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit.Author.Name != s.name || commit.Author.Email != s.email {
+			t.Errorf("gogit read back %q <%s>", commit.Author.Name, commit.Author.Email)
+		}
+	}
+	runGit(t, dir, "git fsck --strict --no-dangling")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// An annotated tag and the ref pointing at it
+type TagRef struct {
+	Name string
+	Oid  *gogit.Oid
+	Tag  *gogit.Tag
+}
+
+func copyTag(tag *gogit.Tag) *gogit.Tag {
+	dup := *tag
+	tagger := *tag.Tagger
+	dup.Tagger = &tagger
+	return &dup
+}
+
+// Returns the message of tag without its signature
+func tagMessage(tag *gogit.Tag) string {
+	message, _ := splitTagSignature([]byte(tag.Message))
+	return string(message)
+}
+
+// Lines that start the signature git appends to the message of a signed tag
+var tagSignaturePrefixes = [][]byte{
+	[]byte("-----BEGIN PGP SIGNATURE-----"),
+	[]byte("-----BEGIN PGP MESSAGE-----"),
+	[]byte("-----BEGIN SSH SIGNATURE-----"),
+	[]byte("-----BEGIN SIGNED MESSAGE-----"),
+}
+
+// Splits a tag message into the message proper and its trailing signature,
+// which starts at the last line git would recognise as a signature.
+func splitTagSignature(message []byte) ([]byte, []byte) {
+	split := len(message)
+	for pos := 0; pos < len(message); {
+		for _, prefix := range tagSignaturePrefixes {
+			if bytes.HasPrefix(message[pos:], prefix) {
+				split = pos
+			}
+		}
+		eol := bytes.IndexByte(message[pos:], '\n')
+		if eol < 0 {
+			break
+		}
+		pos += eol + 1
+	}
+	return message[:split], message[split:]
+}
+
+// Returns a copy of the raw tag data pointing at target. If edit is set,
+// tagger and message are taken from it. Any signature is dropped, and
+// reported as it would no longer match the tag.
+func rewriteTagData(data []byte, target *gogit.Oid, edit *gogit.Tag) ([]byte, bool) {
+	headers, message := splitCommitData(data)
+	if edit != nil {
+		message = []byte(edit.Message)
+	}
+	message, signature := splitTagSignature(message)
+	signed := len(signature) > 0
+
+	var buf bytes.Buffer
+	for _, header := range headers {
+		switch headerKey(header) {
+		case "object":
+			fmt.Fprintf(&buf, "object %s\n", target)
+		case "gpgsig", "gpgsig-sha256":
+			signed = true
+		case "tagger":
+			if edit != nil {
+				fmt.Fprintf(&buf, "tagger %s\n", formatSignature(edit.Tagger))
+			} else {
+				buf.Write(header)
+			}
+		default:
+			buf.Write(header)
+		}
+	}
+	buf.WriteByte('\n')
+	buf.Write(message)
+
+	return buf.Bytes(), signed
+}
+
+// Writes new tag objects for the annotated tags whose commit is replaced by
+// rw and returns the tag refs to move, together with the names of the tags
+// that lost their signature.
+func (r *Repo) rewriteTags(rw *rewriter) ([]*RefUpdate, []string, error) {
+	refs, err := r.listRefs("refs/tags/")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var updates []*RefUpdate
+	var unsigned []string
+	for _, ref := range refs {
+		if objectType, err := r.repository.Type(ref.Oid); err != nil || objectType != gogit.ObjectTag {
+			continue
+		}
+		tag, err := r.repository.LookupTag(ref.Oid)
+		if err != nil {
+			// Tags of trees, blobs or other tags never need rewriting
+			log.Printf("Skipping tag %s: %s", ref.Name, err)
+			continue
+		}
+		target, err := rw.rewrite(tag.TargetId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if target.Equal(tag.TargetId) {
+			continue
+		}
+
+		_, data, err := r.repository.RawObject(ref.Oid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading tag %s: %s", ref.Name, err)
+		}
+		newData, signed := rewriteTagData(data, target, nil)
+		var newOid *gogit.Oid
+		if rw.dryRun {
+			newOid, _ = hashObject("tag", newData)
+		} else if newOid, err = r.writeObject("tag", newData); err != nil {
+			return nil, nil, fmt.Errorf("Error writing tag: %s", err)
+		}
+
+		updates = append(updates, &RefUpdate{Name: ref.Name, Old: ref.Oid, New: newOid})
+		if signed {
+			unsigned = append(unsigned, ref.Name)
+		}
+	}
+	return updates, unsigned, nil
+}
+
+// Lists the annotated tags of commits, sorted by name
+func (r *Repo) AnnotatedTags() ([]*TagRef, error) {
+	refs, err := r.listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []*TagRef
+	for _, ref := range refs {
+		if objectType, err := r.repository.Type(ref.Oid); err != nil || objectType != gogit.ObjectTag {
+			continue
+		}
+		tag, err := r.repository.LookupTag(ref.Oid)
+		if err != nil {
+			log.Printf("Skipping tag %s: %s", ref.Name, err)
+			continue
+		}
+		tags = append(tags, &TagRef{Name: ref.Name, Oid: ref.Oid, Tag: tag})
+	}
+	return tags, nil
+}
+
+// Writes a new object for the annotated tag with the tagger and message of
+// edit, and moves the tag ref to it. Returns a nil result if nothing changed
+// or the rewrite was not confirmed.
+func (r *Repo) SaveTag(tag *TagRef, edit *gogit.Tag) (*RewriteResult, error) {
+	if err := checkSignature("Tagger", edit.Tagger); err != nil {
+		return nil, err
+	}
+	changes := diffTags(tag.Tag, edit)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	_, data, err := r.repository.RawObject(tag.Oid)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading tag %s: %s", tag.Name, err)
+	}
+	newData, signed := rewriteTagData(data, tag.Tag.TargetId, edit)
+	newOid, _ := hashObject("tag", newData)
+
+	result := &RewriteResult{
+		Ref:    tag.Name,
+		OldTip: tag.Oid,
+		NewTip: newOid,
+		Refs:   []*RefUpdate{{Name: tag.Name, Old: tag.Oid, New: newOid}},
+		DryRun: r.DryRun,
+		Plan:   []*PlannedCommit{{OldId: tag.Oid, NewId: newOid, Changes: changes}},
+	}
+	if signed {
+		result.UnsignedTags = []string{tag.Name}
+	}
+	if r.DryRun {
+		log.Printf("Dry run, %s would move: %s -> %s", tag.Name, tag.Oid, newOid)
+		return result, nil
+	}
+	if r.Confirm != nil && !r.Confirm(result) {
+		log.Println("Rewrite not confirmed, not saving.")
+		return nil, nil
+	}
+
+	if _, err := r.writeObject("tag", newData); err != nil {
+		return nil, fmt.Errorf("Error writing tag: %s", err)
+	}
+	tx, err := r.lockRefs(result.Refs)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Rewriting %s: %s -> %s", tag.Name, tag.Oid, newOid)
+	if result.Backup, err = r.createBackup(result.Refs); err != nil {
+		tx.rollback()
+		return nil, err
+	}
+	if err := tx.commit(reflogMessage(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/speedata/gogit"
+
+	"strings"
+	"testing"
+)
+
+// A tag object that looks signed, pointing at HEAD~1
+const signedTagScript = `
+	printf 'object %s\ntype commit\ntag signed\ntagger Tagger <tagger@example.com> 1500000000 +0000\n\nSigned tag\n-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n' \
+		$(git rev-parse HEAD~1) > signed.tag
+	git update-ref refs/tags/signed $(git hash-object -t tag -w signed.tag)
+	rm signed.tag`
+
+func TestRewriteTags(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git commit -q --allow-empty -m three
+		git tag -a -m "First tag" first HEAD~2
+		git tag -a -m "Third tag" third
+		git tag light HEAD~1
+		git tag -a -m "Tree tag" tree HEAD^{tree}`+signedTagScript)
+	repo.AllowPublished = true
+	before := runGit(t, dir, "git rev-parse first tree")
+
+	commit := mustResolve(t, repo, "main~1")
+	commit.Author.Name = "Edited"
+	result, err := repo.SaveCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tags before the edit and of trees stay, the others follow their commits
+	if got := runGit(t, dir, "git rev-parse first tree"); got != before {
+		t.Errorf("untouched tags moved:\n%s\nwant\n%s", got, before)
+	}
+	if got := runGit(t, dir, "git rev-parse third^{commit}"); got != result.NewTip.String() {
+		t.Errorf("third points at %s, want %s", got, result.NewTip)
+	}
+	if got := runGit(t, dir, "git log -1 --format=%an light; git log -1 --format=%an signed^{commit}"); got != "Edited\nEdited" {
+		t.Errorf("light and signed point at commits by:\n%s", got)
+	}
+	if got := runGit(t, dir, "git cat-file tag third"); !strings.Contains(got, "tag third\ntagger Committer <committer@example.com>") || !strings.HasSuffix(got, "\n\nThird tag") {
+		t.Errorf("third not copied:\n%s", got)
+	}
+
+	// The signature would not match the new object, so it is dropped and reported
+	if len(result.UnsignedTags) != 1 || result.UnsignedTags[0] != "refs/tags/signed" {
+		t.Errorf("UnsignedTags = %v", result.UnsignedTags)
+	}
+	if got := runGit(t, dir, "git cat-file tag signed"); strings.Contains(got, "SIGNATURE") || !strings.HasSuffix(got, "\n\nSigned tag") {
+		t.Errorf("signature kept:\n%s", got)
+	}
+	runGit(t, dir, "git fsck --strict")
+
+	// Undo puts back the tags as well
+	if _, err := repo.Undo(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, dir, "git cat-file tag signed"); !strings.Contains(got, "SIGNATURE") {
+		t.Errorf("signed tag not restored:\n%s", got)
+	}
+}
+
+func TestAnnotatedTags(t *testing.T) {
+	repo, _ := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git tag -a -m "B" b
+		git tag -a -m "A" a
+		git tag light`)
+	tags, err := repo.AnnotatedTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Name != "refs/tags/a" || tags[1].Name != "refs/tags/b" {
+		t.Fatalf("AnnotatedTags() = %v", tags)
+	}
+	if tags[0].Tag.Tagger.Name != "Committer" || tagMessage(tags[0].Tag) != "A\n" {
+		t.Errorf("tag a: %+v", tags[0].Tag)
+	}
+}
+
+// editTagFields sets the form fields of editTag on a copy of tag, and fails
+// the test if a value is invalid.
+func editTagFields(t *testing.T, tag *gogit.Tag, values ...string) *gogit.Tag {
+	edited := copyTag(tag)
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		if err := tagFields[i].set(edited, value); err != nil {
+			t.Fatalf("setting %q: %s", tagFields[i].label, err)
+		}
+	}
+	return edited
+}
+
+func TestSaveTag(t *testing.T) {
+	repo, dir := testRepo(t, `
+		git commit -q --allow-empty -m one
+		git commit -q --allow-empty -m two
+		git tag -a -m "Release" -m "Notes" release HEAD~1`+signedTagScript)
+	main := runGit(t, dir, "git rev-parse main")
+	tags, err := repo.AnnotatedTags()
+	if err != nil || len(tags) != 2 || tags[0].Name != "refs/tags/release" {
+		t.Fatalf("AnnotatedTags() = %v, %v", tags, err)
+	}
+	release, signed := tags[0], tags[1]
+
+	// Unchanged fields save nothing
+	if result, err := repo.SaveTag(release, editTagFields(t, release.Tag, "Committer")); err != nil || result != nil {
+		t.Errorf("SaveTag() unchanged = %v, %v", result, err)
+	}
+	if err := tagFields[1].set(copyTag(release.Tag), "no email"); err == nil {
+		t.Error("invalid email accepted")
+	}
+
+	edited := editTagFields(t, release.Tag, "New Tagger", "new@example.com", "2021-02-03 04:05:06", "+0530")
+	edited.Message = "Release\n\nBetter notes\n"
+	repo.DryRun = true
+	result, err := repo.SaveTag(release, edited)
+	if err != nil || result == nil || !result.DryRun {
+		t.Fatalf("dry run SaveTag() = %v, %v", result, err)
+	}
+	if got := runGit(t, dir, "git rev-parse release"); got != release.Oid.String() {
+		t.Errorf("dry run moved release to %s", got)
+	}
+	repo.DryRun = false
+
+	if result, err = repo.SaveTag(release, edited); err != nil || result == nil {
+		t.Fatalf("SaveTag() = %v, %v", result, err)
+	}
+	got := runGit(t, dir, "git cat-file tag release")
+	want := "object " + runGit(t, dir, "git rev-parse main~1") + "\ntype commit\ntag release\n" +
+		"tagger New Tagger <new@example.com> 1612305306 +0530\n\nRelease\n\nBetter notes"
+	if got != want {
+		t.Errorf("release tag:\n%s\nwant\n%s", got, want)
+	}
+	if got := runGit(t, dir, "git rev-parse main"); got != main {
+		t.Errorf("main moved to %s", got)
+	}
+
+	// Editing a signed tag drops its signature, even if the edit kept it
+	edited = editTagFields(t, signed.Tag, "Other Tagger")
+	if result, err = repo.SaveTag(signed, edited); err != nil || result == nil {
+		t.Fatalf("SaveTag() = %v, %v", result, err)
+	}
+	if len(result.UnsignedTags) != 1 || result.UnsignedTags[0] != "refs/tags/signed" {
+		t.Errorf("UnsignedTags = %v", result.UnsignedTags)
+	}
+	if got := runGit(t, dir, "git cat-file tag signed"); strings.Contains(got, "SIGNATURE") || !strings.Contains(got, "tagger Other Tagger") {
+		t.Errorf("signed tag:\n%s", got)
+	}
+	runGit(t, dir, "git fsck --strict")
+
+	// Both edits can be undone
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Undo(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := runGit(t, dir, "git rev-parse release signed"); got != release.Oid.String()+"\n"+signed.Oid.String() {
+		t.Errorf("tags after undo:\n%s", got)
+	}
+}